@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"html"
+	"strings"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+const (
+	animatedPreferMP4 = "prefer-mp4"
+	animatedPreferGIF = "prefer-gif"
+	animatedSkip      = "skip"
+)
+
+var validAnimatedPolicies = map[string]struct{}{
+	animatedPreferMP4: {},
+	animatedPreferGIF: {},
+	animatedSkip:      {},
+}
+
+func isValidAnimatedPolicy(value string) bool {
+	_, ok := validAnimatedPolicies[strings.ToLower(value)]
+	return ok
+}
+
+// applyAnimatedPolicy picks the form of every animated candidate according
+// to policy, or drops them all for the skip policy. An empty policy prefers
+// MP4. Other candidates are kept as they are.
+func applyAnimatedPolicy(candidates []imageCandidate, policy string) []imageCandidate {
+	out := make([]imageCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.MP4URL == "" && candidate.GIFURL == "" {
+			out = append(out, candidate)
+			continue
+		}
+
+		switch policy {
+		case animatedSkip:
+			continue
+		case animatedPreferGIF:
+			if candidate.GIFURL != "" {
+				candidate.URL = candidate.GIFURL
+			}
+		default:
+			if candidate.MP4URL != "" {
+				candidate.URL = candidate.MP4URL
+			}
+		}
+		out = append(out, candidate)
+	}
+
+	return out
+}
+
+// previewAnimation returns the animated form of post from the GIF and MP4
+// variants of its preview, if it has any.
+func previewAnimation(post *models.PostData) (imageCandidate, bool) {
+	if len(post.Preview.Images) == 0 {
+		return imageCandidate{}, false
+	}
+
+	var candidate imageCandidate
+	variants := post.Preview.Images[0].Variants
+	if variants.GIF != nil {
+		candidate.GIFURL = html.UnescapeString(variants.GIF.Source.URL)
+		candidate.Width, candidate.Height = variants.GIF.Source.Width, variants.GIF.Source.Height
+	}
+	if variants.MP4 != nil {
+		candidate.MP4URL = html.UnescapeString(variants.MP4.Source.URL)
+		candidate.Width, candidate.Height = variants.MP4.Source.Width, variants.MP4.Source.Height
+	}
+
+	candidate.URL = candidate.MP4URL
+	if candidate.URL == "" {
+		candidate.URL = candidate.GIFURL
+	}
+
+	return candidate, candidate.URL != ""
+}
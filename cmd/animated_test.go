@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/viper"
+)
+
+// animatedPost decodes a post linking to url whose preview has GIF and MP4
+// variants, as Reddit lists animated posts.
+func animatedPost(t *testing.T, url string) models.Post {
+	t.Helper()
+
+	listing := `{"data": {"children": [{"kind": "t3", "data": {
+		"id": "abc", "title": "Waves", "url": "` + url + `",
+		"preview": {"images": [{
+			"source": {"url": "https://preview.redd.it/waves.gif?width=640&amp;s=a", "width": 640, "height": 360},
+			"variants": {
+				"gif": {"source": {"url": "https://preview.redd.it/waves.gif?s=b", "width": 640, "height": 360}},
+				"mp4": {"source": {"url": "https://preview.redd.it/waves.gif?format=mp4&amp;s=c", "width": 640, "height": 360}}
+			}
+		}]}
+	}}]}}`
+
+	var response models.Response
+	if err := json.Unmarshal([]byte(listing), &response); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+	return response.Data.Post[0]
+}
+
+func TestResolvePostCandidatesFindsMP4OfGIF(t *testing.T) {
+	got, err := resolvePostCandidates(context.Background(), animatedPost(t, "https://i.redd.it/waves.gif"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].GIFURL != "https://i.redd.it/waves.gif" || got[0].MP4URL != "https://preview.redd.it/waves.gif?format=mp4&s=c" {
+		t.Fatalf("expected the GIF with its MP4 variant, got %+v", got)
+	}
+	if ext := imageExtension(got[0].MP4URL); ext != ".mp4" {
+		t.Fatalf("expected the MP4 variant to be saved as .mp4, got %q", ext)
+	}
+}
+
+func TestResolvePostCandidatesFallsBackToPreviewAnimation(t *testing.T) {
+	got, err := resolvePostCandidates(context.Background(), animatedPost(t, "https://gifs.example/watch/waves"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].URL != got[0].MP4URL || got[0].GIFURL != "https://preview.redd.it/waves.gif?s=b" || got[0].Width != 640 {
+		t.Fatalf("expected the preview variants, got %+v", got)
+	}
+}
+
+func TestResolvePostCandidatesResolvesImgurGIFV(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	got, err := resolvePostCandidates(context.Background(), animatedPost(t, "https://i.imgur.com/AbCdE12.gifv"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].MP4URL != "https://i.imgur.com/AbCdE12.mp4" || got[0].GIFURL != "https://i.imgur.com/AbCdE12.gif" || got[0].Height != 360 {
+		t.Fatalf("expected both forms of the Imgur animation, got %+v", got)
+	}
+}
+
+func TestApplyAnimatedPolicy(t *testing.T) {
+	candidates := []imageCandidate{
+		{URL: "https://i.redd.it/still.jpg"},
+		{URL: "https://i.redd.it/waves.gif", GIFURL: "https://i.redd.it/waves.gif", MP4URL: "https://preview.redd.it/waves.gif?format=mp4"},
+		{URL: "https://i.redd.it/only.gif", GIFURL: "https://i.redd.it/only.gif"},
+	}
+
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{animatedPreferMP4, []string{"https://i.redd.it/still.jpg", "https://preview.redd.it/waves.gif?format=mp4", "https://i.redd.it/only.gif"}},
+		{animatedPreferGIF, []string{"https://i.redd.it/still.jpg", "https://i.redd.it/waves.gif", "https://i.redd.it/only.gif"}},
+		{animatedSkip, []string{"https://i.redd.it/still.jpg"}},
+	}
+
+	for _, tt := range tests {
+		got := applyAnimatedPolicy(candidates, tt.policy)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: expected %v, got %+v", tt.policy, tt.want, got)
+		}
+		for i := range got {
+			if got[i].URL != tt.want[i] {
+				t.Fatalf("%s: expected %s, got %s", tt.policy, tt.want[i], got[i].URL)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// authCmd represents the auth command
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Authenticate with the Reddit API",
+	Long: `auth - manage the OAuth2 credentials used to talk to oauth.reddit.com.
+	Credentials are read from the auth section of the config file:
+
+	auth:
+	  client_id: ...      # required
+	  client_secret: ...  # script apps only
+	  username: ...       # script apps only
+	  password: ...       # script apps only
+	  redirect_uri: ...   # installed apps, default ` + defaultRedirectURI + `
+
+	Without a client_id every request is sent anonymously to www.reddit.com.`,
+}
+
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Obtain and store a Reddit access token",
+	Long: `login - for a script app, exchanges the configured username and
+	password for a token. For an installed app, prints an authorization URL and
+	waits for Reddit to redirect back with a code, then stores the refresh token.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		config := loadAuthConfig()
+		if config.ClientID == "" {
+			return errors.New("auth.client_id is not configured")
+		}
+		path, err := tokenPath()
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		var token oauthToken
+		if config.flow() == "script" {
+			token, err = requestToken(cmd.Context(), config, url.Values{
+				"grant_type": {"password"},
+				"username":   {config.Username},
+				"password":   {config.Password},
+			})
+		} else {
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+			token, err = loginInstalledApp(ctx, out, config)
+		}
+		if err != nil {
+			return err
+		}
+		if err := saveToken(path, token); err != nil {
+			return err
+		}
+
+		name, err := fetchIdentity(cmd.Context(), token.AccessToken)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Logged in as", name+". Token stored in", path)
+		return nil
+	},
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show how snoo-dl authenticates with Reddit",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		out := cmd.OutOrStdout()
+		config := loadAuthConfig()
+		if config.ClientID == "" {
+			fmt.Fprintln(out, "Not configured: requests are sent anonymously to www.reddit.com.")
+			return nil
+		}
+
+		path, err := tokenPath()
+		if err != nil {
+			return err
+		}
+		auth, err := newRedditAuthenticator(config, path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "App type:", config.flow())
+		fmt.Fprintln(out, "Token file:", path)
+		fmt.Fprintln(out, "Refresh token:", auth.refreshToken() != "")
+		if !auth.token.Expiry.IsZero() {
+			fmt.Fprintln(out, "Access token expires:", auth.token.Expiry.Local().Format(time.RFC3339))
+		}
+
+		token, err := auth.accessToken(cmd.Context())
+		if err != nil {
+			fmt.Fprintln(out, "Not authenticated:", err)
+			return nil
+		}
+		name, err := fetchIdentity(cmd.Context(), token)
+		if err != nil {
+			fmt.Fprintln(out, "Not authenticated:", err)
+			return nil
+		}
+		fmt.Fprintln(out, "Authenticated as", name)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authStatusCmd)
+	authLoginCmd.Flags().Duration("timeout", 5*time.Minute, "how long to wait for the browser authorization of an installed app")
+}
+
+// loginInstalledApp runs the authorization code flow: it serves
+// config.RedirectURI locally, asks the user to open the authorization URL and
+// exchanges the returned code for a permanent refresh token.
+func loginInstalledApp(ctx context.Context, out io.Writer, config authConfig) (oauthToken, error) {
+	redirect, err := url.Parse(config.RedirectURI)
+	if err != nil || redirect.Host == "" {
+		return oauthToken{}, fmt.Errorf("invalid auth.redirect_uri %q", config.RedirectURI)
+	}
+
+	stateBytes := make([]byte, 16)
+	if _, err := rand.Read(stateBytes); err != nil {
+		return oauthToken{}, err
+	}
+	state := hex.EncodeToString(stateBytes)
+
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return oauthToken{}, err
+	}
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != redirect.Path {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		case query.Get("error") != "":
+			errs <- fmt.Errorf("reddit authorization failed: %s", query.Get("error"))
+		default:
+			codes <- query.Get("code")
+		}
+		fmt.Fprintln(w, "snoo-dl authorization finished, you can close this window.")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authorizeURL := redditAuthorizeURL + "?" + url.Values{
+		"client_id":     {config.ClientID},
+		"response_type": {"code"},
+		"state":         {state},
+		"redirect_uri":  {config.RedirectURI},
+		"duration":      {"permanent"},
+		"scope":         {oauthScopes},
+	}.Encode()
+	fmt.Fprintln(out, "Open this URL in your browser to authorize snoo-dl:")
+	fmt.Fprintln(out, authorizeURL)
+
+	select {
+	case code := <-codes:
+		return requestToken(ctx, config, url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {code},
+			"redirect_uri": {config.RedirectURI},
+		})
+	case err := <-errs:
+		return oauthToken{}, err
+	case <-ctx.Done():
+		return oauthToken{}, fmt.Errorf("waiting for reddit authorization - %w", ctx.Err())
+	}
+}
+
+// fetchIdentity returns the name of the account accessToken belongs to.
+func fetchIdentity(ctx context.Context, accessToken string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, redditOAuthURL+"/api/v1/me", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "bearer "+accessToken)
+	req.Header.Set("User-agent", userAgent)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("reddit identity request failed with status %s", resp.Status)
+	}
+
+	var identity struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&identity); err != nil {
+		return "", err
+	}
+
+	return identity.Name, nil
+}
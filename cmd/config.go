@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// secretConfigKeys are masked by "config show".
+var secretConfigKeys = map[string]struct{}{
+	"auth.client_secret": {},
+	"auth.password":      {},
+	"auth.refresh_token": {},
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the snoo-dl configuration",
+	Long: `config - every download flag can also be set in the config file or as a
+	SNOODL_* environment variable (i.e. SNOODL_LOCATION, SNOODL_ASPECT_RATIO).
+	Flags take precedence over environment variables, which take precedence
+	over the config file.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [download|user]",
+	Short: "Print the effective configuration and where each value comes from",
+	Long: `show - prints the settings a command would run with after merging flags,
+	SNOODL_* environment variables, the config file and defaults.
+	Default: COMMAND=download`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{"download", "user"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target := downloadCmd
+		if len(args) == 1 {
+			found, _, err := rootCmd.Find(args)
+			if err != nil || found == rootCmd || found.Flags().Lookup("location") == nil {
+				return fmt.Errorf("%q does not download posts. Valid commands are: download|user", args[0])
+			}
+			target = found
+		}
+		if err := bindFlags(target); err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if used := viper.ConfigFileUsed(); used != "" {
+			fmt.Fprintln(out, "# config file:", used)
+		} else {
+			fmt.Fprintln(out, "# config file: none")
+		}
+		printEffectiveConfig(out, target)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
+
+// commandConfigKeys maps the flags whose valid values differ between
+// commands to a config key of their own, so a value meant for one command
+// cannot break another: the user sort is read from user.sort
+// (SNOODL_USER_SORT), not from the sort of download.
+var commandConfigKeys = map[string]map[string]string{
+	"user": {"sort": "user.sort"},
+}
+
+// configKey returns the viper key the flag name of cmd is bound to.
+func configKey(cmd *cobra.Command, name string) string {
+	if key, ok := commandConfigKeys[cmd.Name()][name]; ok {
+		return key
+	}
+
+	return name
+}
+
+// bindFlags binds every flag of cmd to its viper key, the flag name unless
+// commandConfigKeys says otherwise, so its value can also come from the
+// config file or a SNOODL_* environment variable.
+func bindFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "config" || flag.Name == "help" {
+			return
+		}
+		if bindErr := viper.BindPFlag(configKey(cmd, flag.Name), flag); bindErr != nil {
+			err = errors.Join(err, bindErr)
+		}
+	})
+
+	return err
+}
+
+// printEffectiveConfig writes every flag of cmd and every auth key as
+// "key = value  # source".
+func printEffectiveConfig(out io.Writer, cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flagNames := make(map[string]string)
+	keys := make([]string, 0)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "config" || flag.Name == "help" {
+			return
+		}
+		key := configKey(cmd, flag.Name)
+		flagNames[key] = flag.Name
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+
+	authKeys := make([]string, 0)
+	for _, key := range viper.AllKeys() {
+		if strings.HasPrefix(key, "auth.") {
+			authKeys = append(authKeys, key)
+		}
+	}
+	sort.Strings(authKeys)
+
+	for _, key := range append(keys, authKeys...) {
+		fmt.Fprintf(out, "%s = %s  # %s\n", key, formatConfigValue(key, viper.Get(key)), configSource(key, flags.Lookup(flagNames[key])))
+	}
+}
+
+// configSource reports which layer the effective value of key comes from,
+// in viper's order of precedence. flag is the flag bound to key, if any.
+func configSource(key string, flag *pflag.Flag) string {
+	if flag != nil && flag.Changed {
+		return "flag"
+	}
+	if _, ok := os.LookupEnv(configEnvVar(key)); ok {
+		return "env " + configEnvVar(key)
+	}
+	if viper.InConfig(key) {
+		return "config"
+	}
+
+	return "default"
+}
+
+// configEnvVar returns the environment variable viper reads for key.
+func configEnvVar(key string) string {
+	return "SNOODL_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+func formatConfigValue(key string, value any) string {
+	if _, ok := secretConfigKeys[key]; ok && fmt.Sprint(value) != "" {
+		return "****"
+	}
+
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, part := range v {
+			parts = append(parts, fmt.Sprint(part))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	return fmt.Sprint(value)
+}
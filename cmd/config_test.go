@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestPrintEffectiveConfigReportsSources(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	t.Setenv("SNOODL_LIMIT", "250")
+
+	// downloadOptionsFromConfig opens the history in the user config
+	// directory and configures every later request.
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	originalRetries := requestRetries
+	originalLimiter := requestLimiter
+	requestLimiter = &rateLimiter{}
+	t.Cleanup(func() {
+		requestRetries = originalRetries
+		requestLimiter = originalLimiter
+	})
+
+	viper.SetEnvPrefix("snoodl")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	viper.AutomaticEnv()
+	viper.SetConfigType("yaml")
+	config := "location: ./wallpapers\naspect-ratio: \"16:9\"\nauth:\n  client_id: abc\n  password: hunter2\n"
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+
+	cmd := &cobra.Command{}
+	addDownloadFlags(cmd)
+	if err := cmd.Flags().Set("concurrency", "8"); err != nil {
+		t.Fatalf("failed to set concurrency flag: %v", err)
+	}
+	if err := bindFlags(cmd); err != nil {
+		t.Fatalf("expected no error binding flags, got %v", err)
+	}
+
+	var out bytes.Buffer
+	printEffectiveConfig(&out, cmd)
+	got := out.String()
+
+	for _, want := range []string{
+		"location = ./wallpapers  # config\n",
+		"aspect-ratio = 16:9  # config\n",
+		"limit = 250  # env SNOODL_LIMIT\n",
+		"concurrency = 8  # flag\n",
+		"duplicates = keep  # default\n",
+		"auth.client_id = abc  # config\n",
+		"auth.password = ****  # config\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	opts, err := downloadOptionsFromConfig(settings{flags: cmd.Flags()})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if opts.Location != "./wallpapers" || opts.Limit != 250 || opts.Concurrency != 8 {
+		t.Fatalf("unexpected merged options: %+v", opts)
+	}
+	if opts.Filter.AspectRatioWidth != 16 || opts.Filter.AspectRatioHeight != 9 {
+		t.Fatalf("unexpected merged filter: %+v", opts.Filter)
+	}
+	if dir, err := os.UserConfigDir(); err != nil || !strings.HasPrefix(opts.History.path, dir) {
+		t.Fatalf("expected the history to be opened below the test config directory, got %q", opts.History.path)
+	}
+}
@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	duplicatesKeep     = "keep"
+	duplicatesSkip     = "skip"
+	duplicatesHardlink = "hardlink"
+
+	// contentIndexFile caches file hashes inside the download location so
+	// unchanged files are not re-hashed on every run.
+	contentIndexFile = ".snoodl-index.json"
+)
+
+var validDuplicatePolicies = map[string]struct{}{
+	duplicatesKeep:     {},
+	duplicatesSkip:     {},
+	duplicatesHardlink: {},
+}
+
+type indexedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+
+	// Width, Height and DHash are filled in once the image has been decoded,
+	// which is not possible for every format.
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	DHash  string `json:"dhash,omitempty"`
+}
+
+// contentIndex maps the SHA-256 of every image below root to its path so a
+// download whose content already exists can be skipped or hardlinked. It is
+// safe for concurrent use, and a nil index keeps every download.
+type contentIndex struct {
+	root   string
+	policy string
+
+	mu     sync.Mutex
+	files  map[string]indexedFile
+	byHash map[string]string
+}
+
+// loadContentIndex hashes every image below root, reusing cached hashes for
+// files whose size and modification time have not changed.
+func loadContentIndex(root string, policy string) (*contentIndex, error) {
+	index := &contentIndex{
+		root:   root,
+		policy: policy,
+		files:  make(map[string]indexedFile),
+		byHash: make(map[string]string),
+	}
+
+	cached, err := readContentIndexCache(root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				if errors.Is(err, os.ErrNotExist) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			// Skip what cannot be read rather than failing the download.
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if path != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isIndexableFile(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		file, ok := cached[rel]
+		if !ok || file.Size != info.Size() || !file.ModTime.Equal(info.ModTime()) {
+			sum, err := hashFile(path)
+			if err != nil {
+				return nil
+			}
+			file = indexedFile{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}
+		}
+
+		index.files[rel] = file
+		if _, ok := index.byHash[file.SHA256]; !ok {
+			index.byHash[file.SHA256] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// openContentIndexCache returns the index cached in root without looking at
+// the files below it, or nil when root has none. It only records new
+// downloads, such as their perceptual hashes for dedupe, and keeps every
+// duplicate.
+func openContentIndexCache(root string) (*contentIndex, error) {
+	cached, err := readContentIndexCache(root)
+	if err != nil || cached == nil {
+		return nil, err
+	}
+
+	return &contentIndex{
+		root:   root,
+		policy: duplicatesKeep,
+		files:  cached,
+		byHash: make(map[string]string),
+	}, nil
+}
+
+// readContentIndexCache reads the cached hashes of root, or returns nil when
+// there are none.
+func readContentIndexCache(root string) (map[string]indexedFile, error) {
+	path := filepath.Join(root, contentIndexFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make(map[string]indexedFile)
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("invalid content index %s - %w", path, err)
+	}
+
+	return cached, nil
+}
+
+// claim returns the existing path holding content sum. When there is none,
+// path is registered as its holder so concurrent downloads of the same
+// content see each other, and claimed is true.
+func (idx *contentIndex) claim(sum string, path string) (existing string, claimed bool) {
+	if idx == nil {
+		return "", false
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if existing, ok := idx.byHash[sum]; ok {
+		return existing, false
+	}
+	idx.byHash[sum] = path
+	return "", true
+}
+
+// release forgets the claim of path on sum, whose file was never written.
+func (idx *contentIndex) release(sum string, path string) {
+	if idx == nil {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.byHash[sum] == path {
+		delete(idx.byHash, sum)
+	}
+}
+
+// save writes the hash cache for every indexed file back to root.
+func (idx *contentIndex) save() error {
+	if idx == nil {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for sum, path := range idx.byHash {
+		rel, err := filepath.Rel(idx.root, path)
+		if err != nil {
+			continue
+		}
+		if _, ok := idx.files[rel]; ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		idx.files[rel] = indexedFile{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}
+	}
+
+	data, err := json.MarshalIndent(idx.files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(idx.root, os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(idx.root, contentIndexFile), data, 0o644)
+}
+
+// finalizeDownload moves the finished download at tmpPath to path, unless
+// the index already holds the same content, in which case the duplicate
+// policy decides what happens. It returns the path now holding the content.
+func finalizeDownload(out io.Writer, index *contentIndex, tmpPath string, path string, sum string) (string, error) {
+	existing, claimed := index.claim(sum, path)
+	if existing != "" && index.policy != duplicatesKeep {
+		os.Remove(tmpPath)
+
+		if index.policy == duplicatesHardlink {
+			if err := os.Link(existing, path); err != nil {
+				return "", fmt.Errorf("error while linking %s to %s - %w", path, existing, err)
+			}
+			fmt.Fprintln(out, "Duplicate of", existing+", hardlinked:", path)
+			return path, nil
+		}
+
+		fmt.Fprintln(out, "Duplicate of", existing+", skipping:", path)
+		return existing, nil
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		if claimed {
+			index.release(sum, path)
+		}
+		return "", fmt.Errorf("error while creating %s - %w", path, err)
+	}
+	index.add(path, sum)
+
+	return path, nil
+}
+
+// add indexes a newly written file, including its perceptual hash when the
+// image is in a format that can be hashed.
+func (idx *contentIndex) add(path string, sum string) {
+	if idx == nil {
+		return
+	}
+
+	rel, err := filepath.Rel(idx.root, path)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	file := indexedFile{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}
+	if canPerceptualHash(path) {
+		if hash, width, height, err := imageDHash(path); err == nil {
+			file.Width, file.Height, file.DHash = width, height, formatDHash(hash)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.files[rel] = file
+}
+
+// fillPerceptual decodes every indexed image that has no perceptual hash
+// yet. Images that cannot be decoded are reported to out and left without one;
+// formats that cannot be hashed at all are skipped silently.
+func (idx *contentIndex) fillPerceptual(out io.Writer) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for rel, file := range idx.files {
+		if file.DHash != "" || !canPerceptualHash(rel) {
+			continue
+		}
+
+		hash, width, height, err := imageDHash(filepath.Join(idx.root, rel))
+		if err != nil {
+			fmt.Fprintln(out, "skipping perceptual hash:", err)
+			continue
+		}
+		file.Width, file.Height, file.DHash = width, height, formatDHash(hash)
+		idx.files[rel] = file
+	}
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// isIndexableFile reports whether name is a downloaded image or video rather
+// than one of snoo-dl's own bookkeeping or in-progress files, such as the
+// streams of a Reddit video left behind by an interrupted mux.
+func isIndexableFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	if strings.HasSuffix(stem, videoStreamSuffix) || strings.HasSuffix(stem, audioStreamSuffix) {
+		return false
+	}
+	if _, ok := supportedVideoExtensions[ext]; ok {
+		return true
+	}
+	_, ok := supportedImageExtensions[ext]
+	return ok
+}
+
+func isValidDuplicatePolicy(value string) bool {
+	_, ok := validDuplicatePolicies[strings.ToLower(value)]
+	return ok
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempDownload(t *testing.T, dir string, content string) (string, string) {
+	t.Helper()
+
+	path := filepath.Join(dir, ".snoodl-test.tmp")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write temp download: %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestLoadContentIndexFindsExistingImages(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), os.ModePerm); err != nil {
+		t.Fatalf("failed to create subdirectory: %v", err)
+	}
+	existing := filepath.Join(root, "sub", "original.jpg")
+	if err := os.WriteFile(existing, []byte("same-bytes"), 0o644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("same-bytes"), 0o644); err != nil {
+		t.Fatalf("failed to write text file: %v", err)
+	}
+
+	index, err := loadContentIndex(root, duplicatesSkip)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(index.files) != 1 {
+		t.Fatalf("expected only the image to be indexed, got %v", index.files)
+	}
+
+	tmpPath, sum := writeTempDownload(t, root, "same-bytes")
+	got, err := finalizeDownload(io.Discard, index, tmpPath, filepath.Join(root, "repost.jpg"), sum)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got != existing {
+		t.Fatalf("expected duplicate to resolve to %q, got %q", existing, got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "repost.jpg")); err == nil {
+		t.Fatal("expected duplicate to be skipped")
+	}
+	if _, err := os.Stat(tmpPath); err == nil {
+		t.Fatal("expected temp download to be removed")
+	}
+
+	if err := index.save(); err != nil {
+		t.Fatalf("expected no error saving, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, contentIndexFile)); err != nil {
+		t.Fatalf("expected content index to be written: %v", err)
+	}
+}
+
+func TestLoadContentIndexKeepsAnimatedDownloads(t *testing.T) {
+	root := t.TempDir()
+	index, err := loadContentIndex(root, duplicatesSkip)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tmpPath, sum := writeTempDownload(t, root, "mp4-bytes")
+	if _, err := finalizeDownload(io.Discard, index, tmpPath, filepath.Join(root, "waves.mp4"), sum); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := index.save(); err != nil {
+		t.Fatalf("expected no error saving, got %v", err)
+	}
+
+	reloaded, err := loadContentIndex(root, duplicatesSkip)
+	if err != nil {
+		t.Fatalf("expected no error reloading, got %v", err)
+	}
+	if file, ok := reloaded.files["waves.mp4"]; !ok || file.SHA256 != sum {
+		t.Fatalf("expected waves.mp4 to stay indexed, got %v", reloaded.files)
+	}
+
+	tmpPath, sum = writeTempDownload(t, root, "mp4-bytes")
+	got, err := finalizeDownload(io.Discard, reloaded, tmpPath, filepath.Join(root, "repost.mp4"), sum)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got != filepath.Join(root, "waves.mp4") {
+		t.Fatalf("expected the repost to resolve to waves.mp4, got %q", got)
+	}
+}
+
+func TestFinalizeDownloadKeepsOtherClaimWhenRenameFails(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, "original.jpg")
+	if err := os.WriteFile(existing, []byte("same-bytes"), 0o644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	index, err := loadContentIndex(root, duplicatesKeep)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tmpPath, sum := writeTempDownload(t, root, "same-bytes")
+	if _, err := finalizeDownload(io.Discard, index, tmpPath, filepath.Join(root, "missing", "copy.jpg"), sum); err == nil {
+		t.Fatal("expected an error renaming into a missing directory")
+	}
+	if got := index.byHash[sum]; got != existing {
+		t.Fatalf("expected %s to still hold the content, got %q", existing, got)
+	}
+}
+
+func TestFinalizeDownloadHardlinksDuplicates(t *testing.T) {
+	root := t.TempDir()
+	index, err := loadContentIndex(root, duplicatesHardlink)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tmpPath, sum := writeTempDownload(t, root, "image-bytes")
+	first := filepath.Join(root, "first.jpg")
+	if _, err := finalizeDownload(io.Discard, index, tmpPath, first, sum); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tmpPath, sum = writeTempDownload(t, root, "image-bytes")
+	second := filepath.Join(root, "second.jpg")
+	if _, err := finalizeDownload(io.Discard, index, tmpPath, second, sum); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	firstInfo, err := os.Stat(first)
+	if err != nil {
+		t.Fatalf("expected first.jpg to exist: %v", err)
+	}
+	secondInfo, err := os.Stat(second)
+	if err != nil {
+		t.Fatalf("expected second.jpg to exist: %v", err)
+	}
+	if !os.SameFile(firstInfo, secondInfo) {
+		t.Fatal("expected second.jpg to be a hardlink of first.jpg")
+	}
+}
+
+func TestFinalizeDownloadKeepsWithoutIndex(t *testing.T) {
+	root := t.TempDir()
+	tmpPath, sum := writeTempDownload(t, root, "image-bytes")
+	path := filepath.Join(root, "kept.jpg")
+
+	got, err := finalizeDownload(io.Discard, nil, tmpPath, path, sum)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got != path {
+		t.Fatalf("expected %q, got %q", path, got)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected kept.jpg to exist: %v", err)
+	}
+}
+
+func TestLoadContentIndexSkipsHiddenDirectories(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{".cache", "sub"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), os.ModePerm); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "image.jpg"), []byte(dir), 0o644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+
+	index, err := loadContentIndex(root, duplicatesSkip)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := index.files[filepath.Join("sub", "image.jpg")]; !ok || len(index.files) != 1 {
+		t.Fatalf("expected only sub/image.jpg to be indexed, got %v", index.files)
+	}
+}
+
+func TestLoadContentIndexSkipsVideoStreams(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"clip.mp4", "clip.video.mp4", "clip.audio.mp4"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	index, err := loadContentIndex(root, duplicatesSkip)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := index.files["clip.mp4"]; !ok || len(index.files) != 1 {
+		t.Fatalf("expected only clip.mp4 to be indexed, got %v", index.files)
+	}
+}
+
+func TestOpenContentIndexCacheOnlyAddsDownloads(t *testing.T) {
+	root := t.TempDir()
+	if index, err := openContentIndexCache(root); err != nil || index != nil {
+		t.Fatalf("expected no index without a cache file, got %v (%v)", index, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, contentIndexFile), []byte("{}"), 0o644); err != nil {
+		t.Fatalf("failed to write content index: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "unindexed.jpg"), []byte("image-bytes"), 0o644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	index, err := openContentIndexCache(root)
+	if err != nil || index == nil {
+		t.Fatalf("expected the cached index, got %v (%v)", index, err)
+	}
+
+	tmpPath, sum := writeTempDownload(t, root, "image-bytes")
+	if _, err := finalizeDownload(io.Discard, index, tmpPath, filepath.Join(root, "new.jpg"), sum); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "new.jpg")); err != nil {
+		t.Fatalf("expected the duplicate to be kept: %v", err)
+	}
+	if _, ok := index.files["new.jpg"]; !ok || len(index.files) != 1 {
+		t.Fatalf("expected only the download to be indexed, got %v", index.files)
+	}
+}
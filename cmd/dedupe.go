@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultDedupeThreshold = 10
+
+// dedupeCmd represents the dedupe command
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe [DIRECTORY]",
+	Short: "Remove near-duplicate images from a directory",
+	Long: `dedupe - will group visually similar images (resized or re-encoded
+	reposts) below DIRECTORY by perceptual hash and keep only the highest
+	resolution copy of each group.
+	Default: DIRECTORY=./`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root := defaultLocation
+		if len(args) == 1 {
+			root = args[0]
+		}
+
+		threshold, _ := cmd.Flags().GetInt("threshold")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if threshold < 0 || threshold > 64 {
+			return errors.New("threshold must be between 0 and 64")
+		}
+
+		return dedupeDirectory(cmd.OutOrStdout(), root, threshold, dryRun)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dedupeCmd)
+	dedupeCmd.Flags().IntP("threshold", "t", defaultDedupeThreshold, "max Hamming distance between perceptual hashes of near-duplicates (0-64)")
+	dedupeCmd.Flags().Bool("dry-run", false, "only print the duplicate groups without removing anything")
+}
+
+// dedupeDirectory removes every near-duplicate below root except the highest
+// resolution copy of each group.
+func dedupeDirectory(out io.Writer, root string, threshold int, dryRun bool) error {
+	index, err := loadContentIndex(root, duplicatesKeep)
+	if err != nil {
+		return err
+	}
+	index.fillPerceptual(out)
+
+	removed := 0
+	for _, group := range groupNearDuplicates(index.files, threshold) {
+		fmt.Fprintln(out, "Keeping", filepath.Join(root, group[0]))
+		for _, rel := range group[1:] {
+			path := filepath.Join(root, rel)
+			if dryRun {
+				fmt.Fprintln(out, "  would remove", path)
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				fmt.Fprintln(out, "  failed to remove", path+":", err)
+				continue
+			}
+			delete(index.files, rel)
+			fmt.Fprintln(out, "  removed", path)
+			removed++
+		}
+	}
+
+	if !dryRun {
+		fmt.Fprintln(out, removed, "near-duplicates removed.")
+	}
+
+	return index.save()
+}
+
+// groupNearDuplicates groups files whose perceptual hashes are within
+// threshold bits of a kept file. Files are considered best first (highest
+// resolution, then largest file) and each one not yet grouped keeps every
+// remaining file within threshold of itself, so a file is only ever grouped
+// with a keeper it is close to. Each returned group has at least two files
+// and starts with the one to keep.
+func groupNearDuplicates(files map[string]indexedFile, threshold int) [][]string {
+	type hashed struct {
+		rel  string
+		hash uint64
+	}
+
+	items := make([]hashed, 0, len(files))
+	for rel, file := range files {
+		if file.DHash == "" {
+			continue
+		}
+		hash, err := parseDHash(file.DHash)
+		if err != nil {
+			continue
+		}
+		items = append(items, hashed{rel: rel, hash: hash})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := files[items[i].rel], files[items[j].rel]
+		if a.Width*a.Height != b.Width*b.Height {
+			return a.Width*a.Height > b.Width*b.Height
+		}
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return items[i].rel < items[j].rel
+	})
+
+	grouped := make([]bool, len(items))
+	groups := make([][]string, 0)
+	for i, keeper := range items {
+		if grouped[i] {
+			continue
+		}
+
+		group := []string{keeper.rel}
+		for j := i + 1; j < len(items); j++ {
+			if !grouped[j] && hammingDistance(keeper.hash, items[j].hash) <= threshold {
+				grouped[j] = true
+				group = append(group, items[j].rel)
+			}
+		}
+		if len(group) > 1 {
+			grouped[i] = true
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+
+	return groups
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	// Register the decoders used for perceptual hashing.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+const (
+	dHashWidth   = 9
+	dHashHeight  = 8
+	dHashSamples = 16
+)
+
+// perceptualHashExtensions are the formats the standard library can fully
+// decode. WebP is missing: only its header is understood (see dimensions.go).
+var perceptualHashExtensions = map[string]struct{}{
+	".jpg":  {},
+	".jpeg": {},
+	".png":  {},
+	".gif":  {},
+}
+
+// canPerceptualHash reports whether the image at path is in a format that
+// imageDHash can decode.
+func canPerceptualHash(path string) bool {
+	_, ok := perceptualHashExtensions[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// imageDHash decodes the image at path and returns its difference hash along
+// with the image dimensions.
+func imageDHash(path string) (uint64, int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("error while decoding %s - %w", path, err)
+	}
+
+	bounds := img.Bounds()
+	return dHash(img), bounds.Dx(), bounds.Dy(), nil
+}
+
+// dHash computes a 64-bit difference hash: the image is shrunk to 9x8
+// grayscale and each bit records whether a pixel is brighter than its right
+// neighbour. Resized or re-encoded copies of an image produce hashes within a
+// small Hamming distance of each other.
+func dHash(img image.Image) uint64 {
+	var gray [dHashHeight][dHashWidth]float64
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return 0
+	}
+
+	for cy := 0; cy < dHashHeight; cy++ {
+		y0 := bounds.Min.Y + cy*height/dHashHeight
+		y1 := bounds.Min.Y + (cy+1)*height/dHashHeight
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for cx := 0; cx < dHashWidth; cx++ {
+			x0 := bounds.Min.X + cx*width/dHashWidth
+			x1 := bounds.Min.X + (cx+1)*width/dHashWidth
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			// Sample at most dHashSamples points per side of each cell so
+			// large wallpapers hash quickly.
+			stepX := max(1, (x1-x0)/dHashSamples)
+			stepY := max(1, (y1-y0)/dHashSamples)
+			var sum float64
+			var count int
+			for y := y0; y < y1; y += stepY {
+				for x := x0; x < x1; x += stepX {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			gray[cy][cx] = sum / float64(count)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+func hammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func formatDHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func parseDHash(value string) (uint64, error) {
+	return strconv.ParseUint(value, 16, 64)
+}
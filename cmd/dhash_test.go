@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// patternImage renders f over the unit square at the given size, so the same
+// f at two sizes behaves like a resized copy.
+func patternImage(width int, height int, f func(u float64, v float64) float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := f(float64(x)/float64(width), float64(y)/float64(height))
+			img.SetGray(x, y, color.Gray{Y: uint8(127.5 + 127.5*value)})
+		}
+	}
+	return img
+}
+
+func wavesPattern(u float64, v float64) float64 {
+	return math.Sin(6*u) * math.Cos(4*v)
+}
+
+func ripplesPattern(u float64, v float64) float64 {
+	return math.Cos(11*u+3) * math.Sin(9*v+1)
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatalf("failed to encode %s: %v", path, err)
+	}
+}
+
+func TestDHashMatchesResizedCopies(t *testing.T) {
+	large := dHash(patternImage(640, 360, wavesPattern))
+	small := dHash(patternImage(160, 90, wavesPattern))
+	other := dHash(patternImage(640, 360, ripplesPattern))
+
+	if d := hammingDistance(large, small); d > 4 {
+		t.Fatalf("expected resized copies to be near-duplicates, distance %d", d)
+	}
+	if d := hammingDistance(large, other); d <= defaultDedupeThreshold {
+		t.Fatalf("expected different images to be far apart, distance %d", d)
+	}
+}
+
+func TestDedupeDirectoryKeepsHighestResolution(t *testing.T) {
+	root := t.TempDir()
+	writePNG(t, filepath.Join(root, "large.png"), patternImage(640, 360, wavesPattern))
+	writePNG(t, filepath.Join(root, "small.png"), patternImage(160, 90, wavesPattern))
+	writePNG(t, filepath.Join(root, "other.png"), patternImage(320, 180, ripplesPattern))
+
+	if err := dedupeDirectory(io.Discard, root, defaultDedupeThreshold, false); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "large.png")); err != nil {
+		t.Fatalf("expected large.png to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "other.png")); err != nil {
+		t.Fatalf("expected other.png to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "small.png")); err == nil {
+		t.Fatal("expected small.png to be removed as a near-duplicate")
+	}
+}
+
+func TestGroupNearDuplicatesIsNotTransitive(t *testing.T) {
+	// b is within the threshold of both a and c, but c is far from a.
+	files := map[string]indexedFile{
+		"a.png": {Width: 640, Height: 360, DHash: formatDHash(0)},
+		"b.png": {Width: 320, Height: 180, DHash: formatDHash(0x3f)},
+		"c.png": {Width: 160, Height: 90, DHash: formatDHash(0xfff)},
+	}
+
+	groups := groupNearDuplicates(files, 6)
+	if len(groups) != 1 || len(groups[0]) != 2 || groups[0][0] != "a.png" || groups[0][1] != "b.png" {
+		t.Fatalf("expected only b.png to be grouped with a.png, got %v", groups)
+	}
+}
+
+func TestFinalizeDownloadHashesImagesWhenKeepingDuplicates(t *testing.T) {
+	root := t.TempDir()
+	index, err := loadContentIndex(root, duplicatesKeep)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, patternImage(64, 36, wavesPattern)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	for _, name := range []string{"first.png", "repost.png"} {
+		tmpPath, sum := writeTempDownload(t, root, encoded.String())
+		if _, err := finalizeDownload(io.Discard, index, tmpPath, filepath.Join(root, name), sum); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	for _, name := range []string{"first.png", "repost.png"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Fatalf("expected %s to be kept: %v", name, err)
+		}
+		if file := index.files[name]; file.DHash == "" || file.Width != 64 {
+			t.Fatalf("expected %s to have a perceptual hash, got %+v", name, file)
+		}
+	}
+}
+
+func TestFillPerceptualSkipsWebP(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "image.webp"), []byte("RIFF\x00\x00\x00\x00WEBP"), 0o644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	index, err := loadContentIndex(root, duplicatesKeep)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var out bytes.Buffer
+	index.fillPerceptual(&out)
+	if out.Len() != 0 {
+		t.Fatalf("expected WebP images to be skipped silently, got %q", out.String())
+	}
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// dimensionCheck reports whether an image of the given size should be kept.
+type dimensionCheck func(width int, height int) error
+
+var (
+	// errDimensionMismatch is wrapped by the error of a dimensionCheck that
+	// rejects an image.
+	errDimensionMismatch = errors.New("does not match the filters")
+
+	// errHeaderRead is wrapped by verifyDimensions when the image header
+	// could not be read at all, as opposed to read and found invalid.
+	errHeaderRead = errors.New("error while reading the image header")
+)
+
+func init() {
+	// Only the header of a WebP image is understood, which is enough for
+	// image.DecodeConfig to report its size.
+	image.RegisterFormat("webp", "RIFF????WEBP", decodeWebP, decodeWebPConfig)
+}
+
+// dimensionCheckFor re-applies filter to candidate using the size decoded from
+// the downloaded image instead of the one Reddit reported.
+func dimensionCheckFor(candidate imageCandidate, filter candidateFilter) dimensionCheck {
+	return func(width int, height int) error {
+		candidate.Width = width
+		candidate.Height = height
+		if !matchesFilter(candidate, filter) {
+			return fmt.Errorf("actual size %dx%d %w", width, height, errDimensionMismatch)
+		}
+		return nil
+	}
+}
+
+// verifyDimensions decodes the size of the image made of the first offset
+// bytes of part followed by body, and passes it to check. It returns the
+// bytes it consumed from body, which the caller still has to write.
+func verifyDimensions(part *os.File, offset int64, body io.Reader, check dimensionCheck) ([]byte, error) {
+	var head bytes.Buffer
+	reader := &recordingReader{r: body}
+	stream := io.MultiReader(io.NewSectionReader(part, 0, offset), io.TeeReader(reader, &head))
+
+	config, _, err := image.DecodeConfig(stream)
+	if reader.err != nil {
+		return head.Bytes(), fmt.Errorf("%w - %w", errHeaderRead, reader.err)
+	}
+	if err != nil {
+		return head.Bytes(), fmt.Errorf("cannot read image size - %w", err)
+	}
+
+	return head.Bytes(), check(config.Width, config.Height)
+}
+
+// recordingReader remembers the first error other than io.EOF that reading
+// r returned.
+type recordingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+var errWebPDecode = errors.New("webp: only the image size can be decoded")
+
+func decodeWebP(io.Reader) (image.Image, error) {
+	return nil, errWebPDecode
+}
+
+// decodeWebPConfig reads the canvas size from the first chunk of a WebP
+// file: VP8X (extended), VP8L (lossless) or VP8 (lossy).
+func decodeWebPConfig(r io.Reader) (image.Config, error) {
+	var header [30]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return image.Config{}, fmt.Errorf("webp: short header - %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return image.Config{}, errors.New("webp: invalid RIFF header")
+	}
+
+	chunk := header[20:]
+	var width, height int
+	switch string(header[12:16]) {
+	case "VP8X":
+		// 24-bit canvas width and height minus one, after 4 bytes of flags.
+		width = 1 + int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16)
+		height = 1 + int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16)
+	case "VP8L":
+		// A 0x2f signature, then 14-bit width and height minus one.
+		if chunk[0] != 0x2f {
+			return image.Config{}, errors.New("webp: invalid VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		width = 1 + int(bits&0x3fff)
+		height = 1 + int(bits>>14&0x3fff)
+	case "VP8 ":
+		// A 3 byte frame tag and the 9d 01 2a start code precede the 14-bit
+		// width and height; the top two bits of each are a scale.
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return image.Config{}, errors.New("webp: invalid VP8 start code")
+		}
+		width = int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+	default:
+		return image.Config{}, fmt.Errorf("webp: unsupported chunk %q", header[12:16])
+	}
+
+	return image.Config{Width: width, Height: height}, nil
+}
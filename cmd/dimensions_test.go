@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+// webpHeader builds the first 30 bytes of a WebP file with the given chunk
+// type and chunk payload.
+func webpHeader(chunkType string, payload []byte) []byte {
+	header := make([]byte, 30)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], 1000)
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], chunkType)
+	binary.LittleEndian.PutUint32(header[16:20], 500)
+	copy(header[20:], payload)
+	return header
+}
+
+func TestDecodeWebPConfig(t *testing.T) {
+	vp8 := []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(vp8[6:8], 1920)
+	binary.LittleEndian.PutUint16(vp8[8:10], 1080)
+
+	vp8l := make([]byte, 10)
+	vp8l[0] = 0x2f
+	binary.LittleEndian.PutUint32(vp8l[1:5], uint32(1920-1)|uint32(1080-1)<<14)
+
+	vp8x := make([]byte, 10)
+	vp8x[4], vp8x[5], vp8x[6] = byte((3840-1)&0xff), byte((3840-1)>>8), 0
+	vp8x[7], vp8x[8], vp8x[9] = byte((2160-1)&0xff), byte((2160-1)>>8), 0
+
+	tests := []struct {
+		name          string
+		header        []byte
+		width, height int
+	}{
+		{"lossy", webpHeader("VP8 ", vp8), 1920, 1080},
+		{"lossless", webpHeader("VP8L", vp8l), 1920, 1080},
+		{"extended", webpHeader("VP8X", vp8x), 3840, 2160},
+	}
+
+	for _, tt := range tests {
+		config, format, err := image.DecodeConfig(bytes.NewReader(tt.header))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.name, err)
+		}
+		if format != "webp" || config.Width != tt.width || config.Height != tt.height {
+			t.Fatalf("%s: expected webp %dx%d, got %s %dx%d", tt.name, tt.width, tt.height, format, config.Width, config.Height)
+		}
+	}
+
+	if _, _, err := image.DecodeConfig(bytes.NewReader(webpHeader("VP8 ", make([]byte, 10)))); err == nil {
+		t.Fatal("expected an error for a missing VP8 start code")
+	}
+}
+
+func encodeTestPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{R: uint8(x), A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadFromURLVerifiesDimensions(t *testing.T) {
+	content := encodeTestPNG(t, 64, 48)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "image.png", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	originalClient := httpClient
+	httpClient = server.Client()
+	defer func() { httpClient = originalClient }()
+
+	location := t.TempDir()
+	candidate := imageCandidate{URL: server.URL + "/image.png"}
+
+	reject := dimensionCheckFor(candidate, models.Filter{MinWidth: 100})
+	if _, err := downloadFromURL(context.Background(), io.Discard, nil, candidate.URL, "small", location, reject); err == nil {
+		t.Fatal("expected an error for an image below the minimum size")
+	}
+	for _, name := range []string{"small.png", "small.png" + partFileSuffix} {
+		if _, err := os.Stat(filepath.Join(location, name)); err == nil {
+			t.Fatalf("expected %s to be deleted", name)
+		}
+	}
+
+	// Resume from a part file holding only the PNG signature, so the size
+	// has to be decoded across the part file and the response.
+	partPath := filepath.Join(location, "landscape.png"+partFileSuffix)
+	if err := os.WriteFile(partPath, content[:8], 0o644); err != nil {
+		t.Fatalf("failed to write part file: %v", err)
+	}
+	accept := dimensionCheckFor(candidate, models.Filter{Orientation: models.OrientationLandscape})
+	path, err := downloadFromURL(context.Background(), io.Discard, nil, candidate.URL, "landscape", location, accept)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected %s to exist: %v", path, err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("expected the verified file to match the original, got %d of %d bytes", len(got), len(content))
+	}
+}
+
+func TestDownloadFromURLKeepsPartFileWhenCancelledDuringVerification(t *testing.T) {
+	content := encodeTestPNG(t, 64, 48)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content[:8])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	originalClient := httpClient
+	httpClient = server.Client()
+	defer func() { httpClient = originalClient }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	location := t.TempDir()
+	candidate := imageCandidate{URL: server.URL + "/image.png"}
+	check := dimensionCheckFor(candidate, models.Filter{MinWidth: 10})
+	if _, err := downloadFromURL(ctx, io.Discard, nil, candidate.URL, "cancelled", location, check); err == nil {
+		t.Fatal("expected an error for a cancelled download")
+	}
+
+	got, err := os.ReadFile(filepath.Join(location, "cancelled.png"+partFileSuffix))
+	if err != nil {
+		t.Fatalf("expected the part file to be kept: %v", err)
+	}
+	if !bytes.Equal(got, content[:8]) {
+		t.Fatalf("expected the part file to hold the received header, got %d bytes", len(got))
+	}
+}
+
+func TestGetTopWallpapersDoesNotRecordDimensionMismatches(t *testing.T) {
+	content := encodeTestPNG(t, 64, 48)
+	var downloads atomic.Int32
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/small.png", func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("/r/test/top.json", func(w http.ResponseWriter, r *http.Request) {
+		out := models.Response{}
+		out.Data.Post = []models.Post{{Data: models.PostData{ID: "abc", Title: "small", URLOverriddenByDest: serverURL + "/img/small.png"}}}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	history, err := openHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	opts := downloadOptions{
+		Subreddits:       []string{"test"},
+		Sort:             "top",
+		Period:           "week",
+		Filter:           models.Filter{MinWidth: 100},
+		VerifyDimensions: true,
+		Location:         t.TempDir(),
+		Limit:            1,
+		Concurrency:      1,
+		History:          history,
+	}
+	for range 2 {
+		if err := getTopWallpapers(context.Background(), opts); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if got := downloads.Load(); got != 2 {
+		t.Fatalf("expected the rejected image to be checked on every run, got %d", got)
+	}
+	if entries := history.list(); len(entries) != 0 {
+		t.Fatalf("expected the mismatch not to be recorded, got %+v", entries)
+	}
+}
+
+func TestUnknownSizeFilterDefersToVerification(t *testing.T) {
+	filter := unknownSizeFilter{models.Filter{MinWidth: 1920}}
+
+	if !filter.Matches(imageCandidate{URL: "https://i.redd.it/a.jpg"}) {
+		t.Fatal("expected a candidate of unknown size to pass")
+	}
+	if filter.Matches(imageCandidate{URL: "https://i.redd.it/b.jpg", Width: 1280, Height: 720}) {
+		t.Fatal("expected a candidate of known size to be filtered")
+	}
+}
+
+func TestUnknownSizeFilterChecksUnverifiableCandidates(t *testing.T) {
+	where, err := parseWhere("width >= 1920")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	filter := unknownSizeFilter{where}
+
+	mp4 := imageCandidate{URL: "https://preview.redd.it/waves.gif?format=mp4", MP4URL: "https://preview.redd.it/waves.gif?format=mp4"}
+	if filter.Matches(mp4) {
+		t.Fatal("expected an MP4 of unknown size to be filtered, since it cannot be verified")
+	}
+	if !filter.Matches(imageCandidate{URL: "https://i.redd.it/a.jpg"}) {
+		t.Fatal("expected an image of unknown size to be deferred to verification")
+	}
+}
+
+func TestUnknownSizeFilterChecksPostTerms(t *testing.T) {
+	where, err := parseWhere("!nsfw && (width >= 1920 || score > 500)")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	filter := unknownSizeFilter{allFilters{models.Filter{MinWidth: 1920}, where}}
+
+	if filter.Matches(imageCandidate{URL: "https://i.redd.it/a.jpg", Post: &models.PostData{Over18: true}}) {
+		t.Fatal("expected an NSFW image of unknown size to be filtered")
+	}
+	if !filter.Matches(imageCandidate{URL: "https://i.redd.it/b.jpg", Post: &models.PostData{Score: 10}}) {
+		t.Fatal("expected an image whose size decides the expression to be deferred to verification")
+	}
+
+	where, err = parseWhere("score > 500 && megapixels > 2")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	filter = unknownSizeFilter{where}
+	if filter.Matches(imageCandidate{URL: "https://i.redd.it/c.jpg", Post: &models.PostData{Score: 10}}) {
+		t.Fatal("expected an image failing a post term to be filtered before its size is known")
+	}
+}
@@ -1,19 +1,25 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 	"unicode"
 
@@ -24,12 +30,17 @@ import (
 var (
 	redditURL = "https://www.reddit.com/r"
 
-	defaultTopPeriod = "week"
-	defaultLocation  = "./"
-	defaultLimit     = 100
+	defaultSort        = "top"
+	defaultTopPeriod   = "week"
+	defaultLocation    = "./"
+	defaultLimit       = 100
+	defaultConcurrency = 4
 
+	// httpClient has no total Timeout, which would cut off large downloads
+	// on slow links. The transport bounds the wait for response headers and
+	// doRequest aborts bodies that stall for requestIdleTimeout.
 	httpClient = &http.Client{
-		Timeout: 30 * time.Second,
+		Transport: newHTTPTransport(30 * time.Second),
 	}
 
 	validTopPeriods = map[string]struct{}{
@@ -40,6 +51,28 @@ var (
 		"all":   {},
 	}
 
+	// validSorts maps each listing sort to whether Reddit honors the
+	// time period (t=) parameter for it.
+	validSorts = map[string]bool{
+		"hot":           false,
+		"new":           false,
+		"rising":        false,
+		"top":           true,
+		"controversial": true,
+	}
+
+	// validSearchSorts are the sorts accepted by the search listing, which
+	// honors the time period for all of them.
+	validSearchSorts = map[string]struct{}{
+		"relevance": {},
+		"hot":       {},
+		"top":       {},
+		"new":       {},
+		"comments":  {},
+	}
+
+	validSubredditName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
 	supportedImageExtensions = map[string]struct{}{
 		".jpg":  {},
 		".jpeg": {},
@@ -47,66 +80,387 @@ var (
 		".webp": {},
 		".gif":  {},
 	}
+
+	// supportedVideoExtensions are only downloaded when a resolver returns
+	// them, never for a bare link.
+	supportedVideoExtensions = map[string]struct{}{
+		".mp4": {},
+	}
 )
 
-type imageCandidate struct {
-	URL    string
-	Width  int
-	Height int
+// downloadOptions describes a single download run.
+type downloadOptions struct {
+	Subreddits  []string
+	Sort        string
+	Period      string
+	Query       string
+	User        string
+	Filter      models.Filter
+	PostFilter  models.PostFilter
+	Location    string
+	Limit       int
+	Concurrency int
+
+	// History records completed downloads. Unless IgnoreHistory is set,
+	// candidates already in it are skipped. A nil History disables both.
+	History       *historyStore
+	IgnoreHistory bool
+
+	// Duplicates is the policy for downloads whose content already exists
+	// below Location: keep (the default), skip or hardlink.
+	Duplicates string
+
+	// Where is the --where expression candidates must also match, if any.
+	Where *whereFilter
+
+	// VerifyDimensions re-applies the filters to the size decoded from each
+	// image as it downloads, deleting those that do not match. Candidates of
+	// unknown size are then downloaded instead of rejected.
+	VerifyDimensions bool
+
+	// Animated is the policy for animated images: prefer-mp4 (the default),
+	// prefer-gif or skip.
+	Animated string
+
+	// Videos enables Reddit-hosted videos, downloaded in the best quality
+	// no taller than MaxHeight (0 for no limit).
+	Videos    bool
+	MaxHeight int
+
+	// NameTemplate names downloads relative to Location. When nil, files are
+	// named after the post title and saved to a directory per subreddit if
+	// several are downloaded.
+	NameTemplate *template.Template
+
+	// Watch, when non-nil, limits the run to posts newer than the marks it
+	// holds and observes the newest post listed in each subreddit. Paging
+	// stops at the first page without any new post.
+	Watch *watchState
+}
+
+// imageCandidate is a downloadable image of a post.
+type imageCandidate = models.Image
+
+// downloadJob is a single candidate queued for a download worker. Seq orders
+// the job's console output relative to every other job in the run.
+type downloadJob struct {
+	Seq      int
+	Header   string
+	PostID   string
+	URL      string
+	Name     string
+	Location string
+
+	// Verify, when non-nil, checks the size decoded from the downloaded
+	// image before the rest of it is written.
+	Verify dimensionCheck
+
+	// DashURL is the DASH manifest of a Reddit video, whose quality is
+	// chosen by MaxHeight. URL is then the video-only fallback.
+	DashURL   string
+	MaxHeight int
+
+	// Caption and OutboundURL of a gallery item, kept in the history.
+	Caption     string
+	OutboundURL string
+
+	// Subreddit and Fullname identify the post to the watch state, which
+	// is told when the download fails.
+	Subreddit string
+	Fullname  string
+}
+
+type downloadResult struct {
+	Seq    int
+	Output string
 }
 
 // downloadCmd represents the download command
 var downloadCmd = &cobra.Command{
-	Use:   "download {SUBREDDIT} [day|week(default)|month|year|all]",
-	Short: "Download images from a specified subreddit",
+	Use:   "download {SUBREDDIT[+SUBREDDIT...]} [day|week(default)|month|year|all]",
+	Short: "Download images from one or more subreddits",
 	Long: `download - will download all images from the specific subreddit.
-	Default: TOP_PERIOD=week, SUBREDDIT=wallpapers`,
-	Args: func(_ *cobra.Command, args []string) error {
-		if len(args) > 2 || len(args) == 0 {
-			return errors.New("invalid arguments")
-		}
-
-		if len(args) == 2 {
-			if !isValidTopPeriod(args[1]) {
-				return errors.New("provided TOP_PERIOD was invalid. Valid periods are: day|week|month|year|all")
-			}
+	Default: SORT=top, TOP_PERIOD=week, SUBREDDIT=wallpapers
+	With --profile the settings of a profile from the config file are used,
+	and flags given on the command line override them.
+	TOP_PERIOD is only accepted for the top and controversial sorts.
+	With --query the subreddit search listing is used instead, which accepts
+	the relevance|hot|top|new|comments sorts and any TOP_PERIOD.
+	Several subreddits can be combined with multireddit syntax (a+b+c) or a
+	repeated --subreddit flag; each one is then saved to its own subdirectory.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		s, err := commandSettings(cmd)
+		if err != nil {
+			return err
 		}
 
-		return nil
+		_, err = downloadListing(s, args)
+		return err
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		subreddit := args[0]
-		topPeriod := defaultTopPeriod
-		if len(args) == 2 {
-			topPeriod = strings.ToLower(args[1])
-		}
-
-		location, _ := cmd.Flags().GetString("location")
-		limit, _ := cmd.Flags().GetInt("limit")
-		resolution, _ := cmd.Flags().GetString("resolution")
-		aspectRatio, _ := cmd.Flags().GetString("aspect-ratio")
-		filter, err := parseFilters(resolution, aspectRatio)
+		s, err := commandSettings(cmd)
 		if err != nil {
 			return err
 		}
-		if limit <= 0 {
-			return errors.New("limit must be greater than 0")
-		}
 
-		if location != "" {
-			return getTopWallpapers(cmd.Context(), subreddit, topPeriod, filter, location, limit)
-		}
-
-		return getTopWallpapers(cmd.Context(), subreddit, topPeriod, filter, defaultLocation, limit)
+		return runDownload(cmd.Context(), s, args)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(downloadCmd)
-	downloadCmd.Flags().StringP("location", "l", defaultLocation, "location to download scraped images")
-	downloadCmd.Flags().Int("limit", defaultLimit, "max number of top posts to process")
-	downloadCmd.Flags().StringP("resolution", "r", "", "only download images with specified resolution (i.e. 1920x1080)")
-	downloadCmd.Flags().StringP("aspect-ratio", "a", "", "only download images that meet specified aspect ratio (i.e. 16:9)")
+	downloadCmd.Flags().StringP("profile", "p", "", "download with the settings of a profile from the config file")
+	addListingFlags(downloadCmd)
+	addDownloadFlags(downloadCmd)
+}
+
+// runDownload downloads the subreddit listing described by s and the
+// positional SUBREDDIT and TOP_PERIOD arguments.
+func runDownload(ctx context.Context, s settings, args []string) error {
+	listing, err := downloadListing(s, args)
+	if err != nil {
+		return err
+	}
+
+	opts, err := downloadOptionsFromConfig(s)
+	if err != nil {
+		return err
+	}
+	opts.Subreddits = listing.Subreddits
+	opts.Sort = listing.Sort
+	opts.Period = listing.Period
+	opts.Query = listing.Query
+
+	return getTopWallpapers(ctx, opts)
+}
+
+// downloadListing resolves and validates the listing to download from the
+// positional SUBREDDIT and TOP_PERIOD arguments and the subreddit, sort,
+// query and period settings. Only the listing fields of the result are set.
+func downloadListing(s settings, args []string) (downloadOptions, error) {
+	if len(args) > 2 {
+		return downloadOptions{}, errors.New("invalid arguments")
+	}
+
+	flagSubreddits := s.getStringSlice("subreddit")
+	subreddit, period := splitDownloadArgs(args, len(flagSubreddits) > 0)
+	if subreddit == "" && len(flagSubreddits) == 0 {
+		return downloadOptions{}, errors.New("a SUBREDDIT argument or --subreddit flag is required")
+	}
+	subreddits, err := parseSubreddits(append([]string{subreddit}, flagSubreddits...))
+	if err != nil {
+		return downloadOptions{}, err
+	}
+
+	sort := strings.ToLower(s.getString("sort"))
+	query := strings.TrimSpace(s.getString("query"))
+	if query != "" {
+		if !isValidSearchSort(sort) {
+			return downloadOptions{}, errors.New("provided SORT was invalid for --query. Valid sorts are: relevance|hot|top|new|comments")
+		}
+	} else if !isValidSort(sort) {
+		return downloadOptions{}, errors.New("provided SORT was invalid. Valid sorts are: hot|new|rising|top|controversial")
+	}
+
+	listing := downloadOptions{
+		Subreddits: subreddits,
+		Sort:       sort,
+		Period:     defaultTopPeriod,
+		Query:      query,
+	}
+
+	// A period from the config file or a profile only applies where Reddit
+	// honors it, while an explicit TOP_PERIOD argument must be valid.
+	if period == "" {
+		if configured := s.getString("period"); configured != "" {
+			if !isValidTopPeriod(configured) {
+				return downloadOptions{}, errors.New("configured period was invalid. Valid periods are: day|week|month|year|all")
+			}
+			listing.Period = strings.ToLower(configured)
+		}
+		return listing, nil
+	}
+
+	if query == "" && !sortHonorsPeriod(sort) {
+		return downloadOptions{}, fmt.Errorf("TOP_PERIOD cannot be used with sort %q", sort)
+	}
+	if !isValidTopPeriod(period) {
+		return downloadOptions{}, errors.New("provided TOP_PERIOD was invalid. Valid periods are: day|week|month|year|all")
+	}
+	listing.Period = strings.ToLower(period)
+
+	return listing, nil
+}
+
+// addListingFlags registers the flags selecting which subreddit listing to
+// download from.
+func addListingFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("subreddit", nil, "subreddit to download from, may be repeated or use a+b syntax")
+	cmd.Flags().StringP("sort", "s", defaultSort, "listing sort to download from (hot|new|rising|top|controversial)")
+	cmd.Flags().StringP("query", "q", "", "only download posts matching a subreddit search query")
+}
+
+// addDownloadFlags registers the flags shared by every command that
+// downloads posts.
+func addDownloadFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("location", "l", defaultLocation, "location to download scraped images")
+	cmd.Flags().Int("limit", defaultLimit, "max number of posts to process")
+	cmd.Flags().IntP("concurrency", "c", defaultConcurrency, "number of images to download in parallel")
+	cmd.Flags().StringP("resolution", "r", "", "only download images with specified resolution (i.e. 1920x1080)")
+	cmd.Flags().StringP("aspect-ratio", "a", "", "only download images that meet specified aspect ratio (i.e. 16:9)")
+	cmd.Flags().String("min-resolution", "", "only download images at least this large (i.e. 1920x1080)")
+	cmd.Flags().String("max-resolution", "", "only download images at most this large (i.e. 3840x2160)")
+	cmd.Flags().Float64("aspect-tolerance", 0, "percentage an image's aspect ratio may deviate from --aspect-ratio (i.e. 1.5)")
+	cmd.Flags().String("orientation", "", "only download images of this orientation (landscape|portrait|square)")
+	cmd.Flags().Bool("verify-dimensions", false, "check the size of each image as it downloads and delete those that do not match the filters")
+	cmd.Flags().String("where", "", "only download images matching this expression (i.e. \"width >= 2560 && ratio ~ 16:9 && !nsfw\")")
+	addPostFilterFlags(cmd)
+	cmd.Flags().String("animated", animatedPreferMP4, "which form of animated images to download (prefer-mp4|prefer-gif|skip)")
+	cmd.Flags().Bool("videos", false, "also download Reddit-hosted (v.redd.it) videos, with audio when ffmpeg is installed")
+	cmd.Flags().Int("max-height", 0, "download videos in the best quality at most this many pixels tall (i.e. 720, 0 for no limit)")
+	cmd.Flags().Bool("ignore-history", false, "download posts even if the download history has already seen them")
+	cmd.Flags().Int("max-retries", defaultMaxRetries, "max number of times to retry a failed or rate-limited request")
+	cmd.Flags().Float64("rate-limit", 0, "max requests per second across all downloads and API calls (0 for no limit)")
+	cmd.Flags().String("name-template", "", "Go template naming downloads, may contain / for subdirectories (i.e. {{.Subreddit}}/{{.ID}}_{{.Index}})")
+	cmd.Flags().String("duplicates", duplicatesKeep, "what to do with images whose content already exists in location (keep|skip|hardlink)")
+}
+
+// downloadOptionsFromConfig reads and validates the settings registered by
+// addDownloadFlags, which come from flags, a profile, SNOODL_* environment
+// variables or the config file. The listing fields of the result are left
+// for the caller.
+func downloadOptionsFromConfig(s settings) (downloadOptions, error) {
+	location := s.getString("location")
+	limit := s.getInt("limit")
+	concurrency := s.getInt("concurrency")
+	resolution := s.getString("resolution")
+	aspectRatio := s.getString("aspect-ratio")
+	filter, err := parseFilters(resolution, aspectRatio)
+	if err != nil {
+		return downloadOptions{}, err
+	}
+	filter, err = parseSizeFilters(filter, s.getString("min-resolution"), s.getString("max-resolution"), s.getFloat64("aspect-tolerance"), s.getString("orientation"))
+	if err != nil {
+		return downloadOptions{}, err
+	}
+	postFilter, err := parsePostFilter(s)
+	if err != nil {
+		return downloadOptions{}, err
+	}
+	var where *whereFilter
+	if expression := strings.TrimSpace(s.getString("where")); expression != "" {
+		where, err = parseWhere(expression)
+		if err != nil {
+			return downloadOptions{}, err
+		}
+	}
+	if limit <= 0 {
+		return downloadOptions{}, errors.New("limit must be greater than 0")
+	}
+	if concurrency <= 0 {
+		return downloadOptions{}, errors.New("concurrency must be greater than 0")
+	}
+	if location == "" {
+		location = defaultLocation
+	}
+	animated := s.getString("animated")
+	if !isValidAnimatedPolicy(animated) {
+		return downloadOptions{}, errors.New("provided animated policy was invalid. Valid policies are: prefer-mp4|prefer-gif|skip")
+	}
+	maxHeight := s.getInt("max-height")
+	if maxHeight < 0 {
+		return downloadOptions{}, errors.New("max-height must not be negative")
+	}
+	maxRetries := s.getInt("max-retries")
+	rateLimit := s.getFloat64("rate-limit")
+	if maxRetries < 0 {
+		return downloadOptions{}, errors.New("max-retries must not be negative")
+	}
+	if rateLimit < 0 {
+		return downloadOptions{}, errors.New("rate-limit must not be negative")
+	}
+	configureRequests(maxRetries, rateLimit)
+
+	nameTemplate, err := parseOptionalNameTemplate(s.getString("name-template"))
+	if err != nil {
+		return downloadOptions{}, err
+	}
+
+	duplicates := s.getString("duplicates")
+	if !isValidDuplicatePolicy(duplicates) {
+		return downloadOptions{}, errors.New("provided duplicates policy was invalid. Valid policies are: keep|skip|hardlink")
+	}
+
+	ignoreHistory := s.getBool("ignore-history")
+	history, err := openDefaultHistory()
+	if err != nil {
+		return downloadOptions{}, err
+	}
+
+	return downloadOptions{
+		Filter:           filter,
+		PostFilter:       postFilter,
+		Where:            where,
+		VerifyDimensions: s.getBool("verify-dimensions"),
+		Animated:         strings.ToLower(animated),
+		Videos:           s.getBool("videos"),
+		MaxHeight:        maxHeight,
+		Location:         location,
+		Limit:            limit,
+		Concurrency:      concurrency,
+		History:          history,
+		IgnoreHistory:    ignoreHistory,
+		Duplicates:       strings.ToLower(duplicates),
+		NameTemplate:     nameTemplate,
+	}, nil
+}
+
+// splitDownloadArgs separates the positional SUBREDDIT and TOP_PERIOD
+// arguments. When --subreddit is used, a lone positional argument that is a
+// valid period is treated as TOP_PERIOD.
+func splitDownloadArgs(args []string, hasSubredditFlag bool) (string, string) {
+	switch {
+	case len(args) == 2:
+		return args[0], args[1]
+	case len(args) == 1 && hasSubredditFlag && isValidTopPeriod(args[0]):
+		return "", args[0]
+	case len(args) == 1:
+		return args[0], ""
+	}
+
+	return "", ""
+}
+
+// parseSubreddits splits every value on "+" and returns the distinct
+// subreddit names in the order they were first given.
+func parseSubreddits(values []string) ([]string, error) {
+	out := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		for _, name := range strings.Split(value, "+") {
+			name = strings.TrimSpace(name)
+			name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "r/")
+			if name == "" {
+				continue
+			}
+			if !validSubredditName.MatchString(name) {
+				return nil, fmt.Errorf("invalid subreddit name %q", name)
+			}
+
+			key := strings.ToLower(name)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			out = append(out, name)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, errors.New("no subreddit provided")
+	}
+
+	return out, nil
 }
 
 func parseFilters(resolution string, aspectRatio string) (models.Filter, error) {
@@ -132,11 +486,118 @@ func parseFilters(resolution string, aspectRatio string) (models.Filter, error)
 	return filter, nil
 }
 
-// timesort = [day | week | month | year | all]
-// location = Path to save images
-func getTopWallpapers(ctx context.Context, subreddit string, timesort string, filter models.Filter, location string, limit int) error {
-	remaining := limit
+// parseSizeFilters adds the size, aspect tolerance and orientation criteria to
+// filter. Empty values leave a criterion unset.
+func parseSizeFilters(filter models.Filter, minResolution string, maxResolution string, aspectTolerance float64, orientation string) (models.Filter, error) {
+	if minResolution != "" {
+		width, height, err := parsePairValue(minResolution, "x", "min-resolution")
+		if err != nil {
+			return filter, err
+		}
+		filter.MinWidth = width
+		filter.MinHeight = height
+	}
+
+	if maxResolution != "" {
+		width, height, err := parsePairValue(maxResolution, "x", "max-resolution")
+		if err != nil {
+			return filter, err
+		}
+		filter.MaxWidth = width
+		filter.MaxHeight = height
+	}
+
+	if filter.MaxWidth > 0 && (filter.MinWidth > filter.MaxWidth || filter.MinHeight > filter.MaxHeight) {
+		return filter, errors.New("min-resolution must not exceed max-resolution")
+	}
+
+	if aspectTolerance < 0 {
+		return filter, errors.New("aspect-tolerance must not be negative")
+	}
+	filter.AspectTolerance = aspectTolerance
+
+	switch orientation = strings.ToLower(orientation); orientation {
+	case "", models.OrientationLandscape, models.OrientationPortrait, models.OrientationSquare:
+		filter.Orientation = orientation
+	default:
+		return filter, errors.New("provided orientation was invalid. Valid orientations are: landscape|portrait|square")
+	}
+
+	return filter, nil
+}
+
+// getTopWallpapers downloads every matching image from the listing described
+// by opts, which may be a subreddit, search or user listing. Multiple
+// subreddits are fetched as one multireddit listing and each is saved to its
+// own subdirectory of opts.Location.
+func getTopWallpapers(ctx context.Context, opts downloadOptions) error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	// Only skip and hardlink need every file below the location indexed.
+	// Otherwise downloads are just added to an index dedupe already wrote.
+	var index *contentIndex
+	var err error
+	if opts.Duplicates == duplicatesSkip || opts.Duplicates == duplicatesHardlink {
+		index, err = loadContentIndex(opts.Location, opts.Duplicates)
+	} else {
+		index, err = openContentIndexCache(opts.Location)
+	}
+	if err != nil {
+		return err
+	}
+
+	jobs := make(chan downloadJob)
+	results := make(chan downloadResult)
+
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				results <- runDownloadJob(ctx, job, opts.History, index, opts.Watch)
+			}
+		}()
+	}
+
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		printOrdered(os.Stdout, results)
+	}()
+
+	err = queueTopWallpapers(ctx, opts, jobs)
+	close(jobs)
+	workers.Wait()
+	close(results)
+	<-printed
+
+	if saveErr := index.save(); saveErr != nil {
+		fmt.Println("failed to save content index:", saveErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+// queueTopWallpapers pages through the listing and sends every matching
+// candidate to jobs. Candidate URLs are deduplicated across the whole run.
+// It stops early when ctx is cancelled.
+func queueTopWallpapers(ctx context.Context, opts downloadOptions, jobs chan<- downloadJob) error {
+	remaining := opts.Limit
 	after := ""
+	seq := 0
+	seen := make(map[string]struct{})
+	filter := opts.candidateFilter()
+	prefilter := filter
+	if opts.VerifyDimensions {
+		prefilter = unknownSizeFilter{filter}
+	}
 
 	for remaining > 0 {
 		pageLimit := remaining
@@ -144,7 +605,7 @@ func getTopWallpapers(ctx context.Context, subreddit string, timesort string, fi
 			pageLimit = 100
 		}
 
-		responseObject, err := fetchTopPage(ctx, subreddit, timesort, after, pageLimit)
+		responseObject, err := fetchListingPage(ctx, opts.pageURL(after, pageLimit))
 		if err != nil {
 			return err
 		}
@@ -154,35 +615,130 @@ func getTopWallpapers(ctx context.Context, subreddit string, timesort string, fi
 			return nil
 		}
 
+		pageHasNew := false
 		for _, post := range posts {
-			candidates := extractCandidateImageURLs(post)
+			subreddit := post.Data.Subreddit
+			if subreddit == "" {
+				subreddit = strings.Join(opts.Subreddits, "+")
+			}
+			fullname := "t3_" + post.Data.ID
+			if opts.Watch != nil {
+				if !opts.Watch.isNew(subreddit, fullname) {
+					continue
+				}
+				pageHasNew = true
+				opts.Watch.observe(subreddit, fullname)
+			}
+
+			if !matchesPostFilter(post.Data, opts.PostFilter) {
+				continue
+			}
+
+			candidates, err := resolvePostCandidates(ctx, post)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// Report the failure in queue order and move on.
+				if isTransientError(err) {
+					opts.Watch.fail(subreddit, fullname)
+				}
+				job := downloadJob{Seq: seq, Header: fmt.Sprintf("%s => skipping %s: %v\n", post.Data.Title, post.Data.Url, err)}
+				seq++
+				select {
+				case jobs <- job:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				continue
+			}
+			candidates = applyAnimatedPolicy(candidates, opts.Animated)
+			if !opts.Videos {
+				candidates = slices.DeleteFunc(candidates, func(candidate imageCandidate) bool {
+					return candidate.DashURL != ""
+				})
+			}
 			if len(candidates) == 0 {
 				continue
 			}
 
-			filteredCandidates := filterCandidates(candidates, filter)
+			filteredCandidates := uniqueCandidates(filterCandidates(candidates, prefilter), seen)
+			if !opts.IgnoreHistory {
+				filteredCandidates = opts.History.unseen(post.Data.ID, filteredCandidates)
+			}
 			if len(filteredCandidates) == 0 {
 				continue
 			}
 
+			location := opts.Location
+			if opts.NameTemplate == nil && len(opts.Subreddits) > 1 && post.Data.Subreddit != "" {
+				location = filepath.Join(opts.Location, sanitizeFilename(post.Data.Subreddit))
+			}
+
+			// Gallery items are numbered by their position in the gallery, so
+			// their names do not shift when earlier items are skipped.
+			galleryOnly := true
 			urls := make([]string, 0, len(filteredCandidates))
 			for _, candidate := range filteredCandidates {
 				urls = append(urls, candidate.URL)
+				galleryOnly = galleryOnly && candidate.GalleryIndex > 0
 			}
-			fmt.Println(post.Data.Title + " => " + strings.Join(urls, ", "))
+			header := post.Data.Title + " => " + strings.Join(urls, ", ") + "\n"
+			taken := make(map[string]struct{}, len(filteredCandidates))
 			for i, candidate := range filteredCandidates {
-				name := post.Data.Title
-				if i > 0 {
-					name = fmt.Sprintf("%s_%d", post.Data.Title, i+1)
+				index := i + 1
+				if galleryOnly {
+					index = candidate.GalleryIndex
+				}
+				name := sanitizeFilename(post.Data.Title)
+				if index > 1 {
+					name = fmt.Sprintf("%s_%d", name, index)
+				}
+				if opts.NameTemplate != nil {
+					name, err = renderName(opts.NameTemplate, postNameFields(candidate, index))
+					if err != nil {
+						return fmt.Errorf("error while naming %s - %w", candidate.URL, err)
+					}
+				}
+				// A template without {{.Index}} names every image of a post
+				// alike; number the later ones instead of skipping them.
+				if _, ok := taken[name+imageExtension(candidate.URL)]; ok {
+					name = fmt.Sprintf("%s_%d", name, index)
 				}
-				if err := downloadFromURL(ctx, candidate.URL, name, location); err != nil {
-					fmt.Println("skipping download:", err)
+				taken[name+imageExtension(candidate.URL)] = struct{}{}
+
+				job := downloadJob{
+					Seq:         seq,
+					PostID:      post.Data.ID,
+					URL:         candidate.URL,
+					Name:        name,
+					Location:    location,
+					Caption:     candidate.Caption,
+					OutboundURL: candidate.OutboundURL,
+					Subreddit:   subreddit,
+					Fullname:    fullname,
+				}
+				if i == 0 {
+					job.Header = header
+				}
+				if candidate.DashURL != "" {
+					job.DashURL = candidate.DashURL
+					job.MaxHeight = opts.MaxHeight
+				} else if opts.VerifyDimensions && canVerifyDimensions(candidate) {
+					job.Verify = dimensionCheckFor(candidate, filter)
+				}
+				seq++
+
+				select {
+				case jobs <- job:
+				case <-ctx.Done():
+					return ctx.Err()
 				}
 			}
 		}
 
 		remaining -= len(posts)
-		if responseObject.Data.After == "" {
+		if responseObject.Data.After == "" || (opts.Watch != nil && !pageHasNew) {
 			break
 		}
 		after = responseObject.Data.After
@@ -191,119 +747,264 @@ func getTopWallpapers(ctx context.Context, subreddit string, timesort string, fi
 	return nil
 }
 
-func fetchTopPage(ctx context.Context, subreddit string, timesort string, after string, limit int) (models.Response, error) {
-	var responseObject models.Response
+// runDownloadJob downloads a single job, buffering everything it would print
+// so the caller can emit it in queue order. Successful downloads are recorded
+// in history and transient failures reported to watch, so the post is tried
+// again, when they are non-nil. A job without a URL only prints its header.
+func runDownloadJob(ctx context.Context, job downloadJob, history *historyStore, index *contentIndex, watch *watchState) downloadResult {
+	result := downloadResult{Seq: job.Seq}
+	if ctx.Err() != nil {
+		return result
+	}
 
-	requestURL := fmt.Sprintf("%s/%s/top.json?t=%s&limit=%d", redditURL, subreddit, timesort, limit)
-	if after != "" {
-		requestURL += "&after=" + url.QueryEscape(after)
+	var out strings.Builder
+	out.WriteString(job.Header)
+	if job.URL == "" {
+		result.Output = out.String()
+		return result
 	}
+	var path string
+	var err error
+	if job.DashURL != "" {
+		path, err = downloadRedditVideo(ctx, &out, job)
+	} else {
+		path, err = downloadFromURL(ctx, &out, index, job.URL, job.Name, job.Location, job.Verify)
+	}
+	if errors.Is(err, errDimensionMismatch) {
+		// The image is fine, it just does not match this run's filters, so
+		// it is neither recorded nor retried.
+		fmt.Fprintln(&out, "skipping download:", err)
+	} else if err != nil {
+		fmt.Fprintln(&out, "skipping download:", err)
+		if isTransientError(err) {
+			watch.fail(job.Subreddit, job.Fullname)
+		}
+	} else if err := history.record(historyEntry{PostID: job.PostID, URL: job.URL, Path: path, Caption: job.Caption, OutboundURL: job.OutboundURL}); err != nil {
+		fmt.Fprintln(&out, "failed to record history:", err)
+	}
+	result.Output = out.String()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
-	if err != nil {
-		return responseObject, err
+	return result
+}
+
+// printOrdered writes results to w in sequence order, holding back any
+// result that arrives before its predecessors have been written.
+func printOrdered(w io.Writer, results <-chan downloadResult) {
+	pending := make(map[int]string)
+	next := 0
+	for result := range results {
+		pending[result.Seq] = result.Output
+		for {
+			output, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			io.WriteString(w, output)
+			next++
+		}
 	}
+}
 
-	req.Header.Set("User-agent", "snoo-dl/0.1")
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return responseObject, err
+// candidateFilter returns the filter every candidate of the run must match.
+func (opts downloadOptions) candidateFilter() candidateFilter {
+	if opts.Where == nil {
+		return opts.Filter
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return responseObject, fmt.Errorf("reddit request failed with status %s", resp.Status)
+	return allFilters{opts.Filter, opts.Where}
+}
+
+// pageURL returns the URL of the listing page starting after the given
+// fullname. opts.User selects the user's submissions and opts.Query the
+// search listing; otherwise the subreddit listing is used.
+func (opts downloadOptions) pageURL(after string, limit int) string {
+	if opts.User != "" {
+		return userSubmittedURL(opts.User, opts.Sort, opts.Period, after, limit)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&responseObject); err != nil {
-		return responseObject, err
+	subreddit := strings.Join(opts.Subreddits, "+")
+	if opts.Query != "" {
+		return searchURL(subreddit, opts.Query, opts.Sort, opts.Period, after, limit)
 	}
 
-	return responseObject, nil
+	return listingURL(subreddit, opts.Sort, opts.Period, after, limit)
+}
+
+// fetchListingPage requests a listing page, authenticating it when OAuth2 is
+// configured. A rejected access token is refreshed and the page requested
+// once more.
+func fetchListingPage(ctx context.Context, requestURL string) (models.Response, error) {
+	var responseObject models.Response
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return responseObject, err
+		}
+
+		req.Header.Set("User-agent", userAgent)
+		if err := redditAuth.authorize(req); err != nil {
+			return responseObject, err
+		}
+		resp, err := doRequest(req)
+		if err != nil {
+			return responseObject, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized && redditAuth != nil && attempt == 0 {
+			resp.Body.Close()
+			redditAuth.invalidate()
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return responseObject, fmt.Errorf("reddit request failed with status %s", resp.Status)
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&responseObject); err != nil {
+			return responseObject, err
+		}
+
+		return responseObject, nil
+	}
 }
 
-func downloadFromURL(ctx context.Context, downloadURL string, title string, location string) error {
+// downloadFromURL saves downloadURL as name, a slash separated path relative
+// to location, and returns the path of the file, which may already have
+// existed. The content is written to a .part file, resumed with a Range
+// request if one is left from an earlier attempt, and hashed and checked
+// against index before the file is finalized.
+func downloadFromURL(ctx context.Context, out io.Writer, index *contentIndex, downloadURL string, name string, location string, verify dimensionCheck) (string, error) {
 	fileExt := imageExtension(downloadURL)
-	fileName := fmt.Sprintf("%s%s", sanitizeFilename(title), fileExt)
-	fmt.Println("Downloading", downloadURL, "to", fileName)
+	fileName := fmt.Sprintf("%s%s", sanitizeFilePath(name), fileExt)
+	fmt.Fprintln(out, "Downloading", downloadURL, "to", fileName)
 
 	if location == "" {
 		location = defaultLocation
 	}
 
-	if err := os.MkdirAll(location, os.ModePerm); err != nil {
-		return err
+	path := filepath.Join(location, filepath.FromSlash(fileName))
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
 	}
 
-	path := filepath.Join(location, fileName)
 	if _, err := os.Stat(path); err == nil {
-		fmt.Println("File already exists, skipping:", path)
-		return nil
+		fmt.Fprintln(out, "File already exists, skipping:", path)
+		return path, nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
-	if err != nil {
-		return err
+	partPath := path + partFileSuffix
+	release, ok := claimPartFile(partPath)
+	if !ok {
+		return "", fmt.Errorf("%s is already being downloaded", path)
 	}
+	defer release()
 
-	response, err := httpClient.Do(req)
+	output, offset, err := openPartFile(partPath)
 	if err != nil {
-		return fmt.Errorf("error while downloading %s - %w", downloadURL, err)
+		return "", fmt.Errorf("error while creating %s - %w", fileName, err)
 	}
-	defer response.Body.Close()
+	defer output.Close()
 
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status %s", response.Status)
+	// removeEmptyPart drops a part file this call created before any of the
+	// body was written, so a failed request leaves nothing behind.
+	removeEmptyPart := func() {
+		if offset == 0 {
+			output.Close()
+			os.Remove(partPath)
+		}
 	}
 
-	output, err := os.Create(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
 	if err != nil {
-		return fmt.Errorf("error while creating %s - %w", fileName, err)
+		removeEmptyPart()
+		return "", err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
-	defer output.Close()
 
-	n, err := io.Copy(output, response.Body)
+	response, err := doRequest(req)
 	if err != nil {
-		return fmt.Errorf("error while downloading %s - %w", downloadURL, err)
+		removeEmptyPart()
+		return "", fmt.Errorf("error while downloading %s - %w", downloadURL, err)
 	}
-	fmt.Println(n, "bytes downloaded.")
-
-	return nil
-}
-
-func extractCandidateImageURLs(post models.Post) []imageCandidate {
-	candidates := make([]imageCandidate, 0, 4)
+	defer response.Body.Close()
 
-	previewWidth, previewHeight := 0, 0
-	if len(post.Data.Preview.Images) > 0 {
-		previewWidth = post.Data.Preview.Images[0].Source.Width
-		previewHeight = post.Data.Preview.Images[0].Source.Height
+	switch {
+	case offset > 0 && response.StatusCode == http.StatusPartialContent:
+		if start, ok := contentRangeStart(response.Header.Get("Content-Range")); !ok || start != offset {
+			os.Remove(partPath)
+			return "", fmt.Errorf("unexpected Content-Range %q resuming %s", response.Header.Get("Content-Range"), downloadURL)
+		}
+		fmt.Fprintln(out, "Resuming", fileName, "from byte", offset)
+	case response.StatusCode == http.StatusOK:
+		// The server ignored or was not sent a Range header, so start over.
+		if offset > 0 {
+			if err := restartPartFile(output); err != nil {
+				return "", fmt.Errorf("error while creating %s - %w", fileName, err)
+			}
+			offset = 0
+		}
+	default:
+		if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			os.Remove(partPath)
+		}
+		removeEmptyPart()
+		return "", newStatusError("download", response)
 	}
 
-	add := func(rawURL string, width int, height int) {
-		unescaped := html.UnescapeString(strings.TrimSpace(rawURL))
-		if unescaped == "" || !hasSupportedImageExtension(unescaped) {
-			return
+	hasher := sha256.New()
+	if offset > 0 {
+		if err := hashPartPrefix(output, hasher); err != nil {
+			return "", fmt.Errorf("error while resuming %s - %w", fileName, err)
 		}
-		candidates = append(candidates, imageCandidate{
-			URL:    unescaped,
-			Width:  width,
-			Height: height,
-		})
 	}
 
-	add(post.Data.URLOverriddenByDest, previewWidth, previewHeight)
-	add(post.Data.Url, previewWidth, previewHeight)
-
-	if post.Data.IsGallery {
-		for _, item := range post.Data.GalleryData.Items {
-			if meta, ok := post.Data.MediaMetadata[item.MediaID]; ok {
-				add(meta.S.U, meta.S.X, meta.S.Y)
+	body := io.Reader(response.Body)
+	if verify != nil {
+		head, err := verifyDimensions(output, offset, response.Body, verify)
+		if errors.Is(err, errHeaderRead) {
+			// The transfer failed rather than the image: keep what arrived
+			// so it can be resumed.
+			_, writeErr := output.Write(head)
+			output.Close()
+			if writeErr != nil || (ctx.Err() == nil && !acceptsRanges(response)) {
+				os.Remove(partPath)
 			}
+			return "", fmt.Errorf("error while downloading %s - %w", downloadURL, err)
 		}
+		if err != nil {
+			output.Close()
+			os.Remove(partPath)
+			return "", err
+		}
+		body = io.MultiReader(bytes.NewReader(head), response.Body)
 	}
 
-	return uniqueCandidates(candidates)
+	n, err := io.Copy(io.MultiWriter(output, hasher), body)
+	closeErr := output.Close()
+	if err != nil {
+		if !acceptsRanges(response) {
+			os.Remove(partPath)
+		}
+		return "", fmt.Errorf("error while downloading %s - %w", downloadURL, err)
+	}
+	if closeErr != nil {
+		return "", fmt.Errorf("error while creating %s - %w", fileName, closeErr)
+	}
+	fmt.Fprintln(out, n, "bytes downloaded.")
+
+	return finalizeDownload(out, index, partPath, path, hex.EncodeToString(hasher.Sum(nil)))
+}
+
+// newHTTPTransport returns a copy of the default transport that gives up on
+// a server that has not sent response headers within headerTimeout.
+func newHTTPTransport(headerTimeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = headerTimeout
+	return transport
 }
 
 func parsePairValue(raw string, separator string, fieldName string) (int, int, error) {
@@ -326,6 +1027,50 @@ func parsePairValue(raw string, separator string, fieldName string) (int, int, e
 	return left, right, nil
 }
 
+// listingURL builds the JSON listing URL for subreddit, only adding the time
+// period for sorts that honor it.
+func listingURL(subreddit string, sort string, timesort string, after string, limit int) string {
+	query := url.Values{}
+	if sortHonorsPeriod(sort) {
+		query.Set("t", timesort)
+	}
+	query.Set("limit", strconv.Itoa(limit))
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	return fmt.Sprintf("%s/%s/%s.json?%s", redditURL, subreddit, strings.ToLower(sort), query.Encode())
+}
+
+// searchURL builds the JSON search URL for posts in subreddit matching q.
+func searchURL(subreddit string, q string, sort string, timesort string, after string, limit int) string {
+	query := url.Values{}
+	query.Set("q", q)
+	query.Set("restrict_sr", "1")
+	query.Set("sort", strings.ToLower(sort))
+	query.Set("t", timesort)
+	query.Set("limit", strconv.Itoa(limit))
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	return fmt.Sprintf("%s/%s/search.json?%s", redditURL, subreddit, query.Encode())
+}
+
+func isValidSearchSort(value string) bool {
+	_, ok := validSearchSorts[strings.ToLower(value)]
+	return ok
+}
+
+func isValidSort(value string) bool {
+	_, ok := validSorts[strings.ToLower(value)]
+	return ok
+}
+
+func sortHonorsPeriod(value string) bool {
+	return validSorts[strings.ToLower(value)]
+}
+
 func isValidTopPeriod(value string) bool {
 	_, ok := validTopPeriods[strings.ToLower(value)]
 	return ok
@@ -343,19 +1088,27 @@ func imageExtension(rawURL string) string {
 		return ""
 	}
 
+	queryFormat := strings.ToLower(parsedURL.Query().Get("format"))
+	if queryFormat != "" && !strings.HasPrefix(queryFormat, ".") {
+		queryFormat = "." + queryFormat
+	}
+
+	// Animated preview variants keep the path of the original GIF and are
+	// asked for as an MP4 with format=mp4.
+	if _, ok := supportedVideoExtensions[queryFormat]; ok {
+		return queryFormat
+	}
+
 	ext := strings.ToLower(path.Ext(parsedURL.Path))
 	if _, ok := supportedImageExtensions[ext]; ok {
 		return ext
 	}
+	if _, ok := supportedVideoExtensions[ext]; ok {
+		return ext
+	}
 
-	queryFormat := strings.ToLower(parsedURL.Query().Get("format"))
-	if queryFormat != "" {
-		if !strings.HasPrefix(queryFormat, ".") {
-			queryFormat = "." + queryFormat
-		}
-		if _, ok := supportedImageExtensions[queryFormat]; ok {
-			return queryFormat
-		}
+	if _, ok := supportedImageExtensions[queryFormat]; ok {
+		return queryFormat
 	}
 
 	return ""
@@ -387,7 +1140,7 @@ func sanitizeFilename(name string) string {
 	return clean
 }
 
-func filterCandidates(candidates []imageCandidate, filter models.Filter) []imageCandidate {
+func filterCandidates(candidates []imageCandidate, filter candidateFilter) []imageCandidate {
 	if len(candidates) == 0 {
 		return nil
 	}
@@ -403,31 +1156,23 @@ func filterCandidates(candidates []imageCandidate, filter models.Filter) []image
 	return out
 }
 
-func matchesFilter(candidate imageCandidate, filter models.Filter) bool {
-	if (models.Filter{}) == filter {
-		return true
-	}
-
-	if candidate.Width <= 0 || candidate.Height <= 0 {
-		return false
-	}
-
-	hasResolutionFilter := filter.ResolutionWidth > 0 && filter.ResolutionHeight > 0
-	hasAspectRatioFilter := filter.AspectRatioWidth > 0 && filter.AspectRatioHeight > 0
-
-	resolutionMatch := !hasResolutionFilter || (candidate.Height == filter.ResolutionHeight && candidate.Width == filter.ResolutionWidth)
-	aspectRatioMatch := !hasAspectRatioFilter || (candidate.Width*filter.AspectRatioHeight == candidate.Height*filter.AspectRatioWidth)
-
-	return resolutionMatch && aspectRatioMatch
+// matchesFilter reports whether candidate passes filter. A nil filter
+// matches everything.
+func matchesFilter(candidate imageCandidate, filter candidateFilter) bool {
+	return filter == nil || filter.Matches(candidate)
 }
 
-func uniqueCandidates(values []imageCandidate) []imageCandidate {
+// uniqueCandidates drops candidates whose URL is already in seen and records
+// the rest. A nil seen only deduplicates within values.
+func uniqueCandidates(values []imageCandidate, seen map[string]struct{}) []imageCandidate {
 	if len(values) == 0 {
 		return nil
 	}
 
 	out := make([]imageCandidate, 0, len(values))
-	seen := make(map[string]struct{}, len(values))
+	if seen == nil {
+		seen = make(map[string]struct{}, len(values))
+	}
 	for _, value := range values {
 		if _, ok := seen[value.URL]; ok {
 			continue
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -9,8 +10,11 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func TestParseFiltersValid(t *testing.T) {
@@ -52,6 +56,96 @@ func TestIsValidTopPeriod(t *testing.T) {
 	}
 }
 
+func TestListingURL(t *testing.T) {
+	originalRedditURL := redditURL
+	redditURL = "https://reddit.test/r"
+	defer func() { redditURL = originalRedditURL }()
+
+	got := listingURL("wallpapers", "controversial", "month", "t3_abc", 50)
+	want := "https://reddit.test/r/wallpapers/controversial.json?after=t3_abc&limit=50&t=month"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	got = listingURL("wallpapers", "new", "month", "", 25)
+	want = "https://reddit.test/r/wallpapers/new.json?limit=25"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSearchURL(t *testing.T) {
+	originalRedditURL := redditURL
+	redditURL = "https://reddit.test/r"
+	defer func() { redditURL = originalRedditURL }()
+
+	got := searchURL("spaceporn", "nebula title", "new", "all", "", 100)
+	want := "https://reddit.test/r/spaceporn/search.json?limit=100&q=nebula+title&restrict_sr=1&sort=new&t=all"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+// newDownloadArgsCommand returns a command carrying download's listing flags
+// for exercising downloadCmd.Args, and resets the viper bindings it creates.
+func newDownloadArgsCommand(t *testing.T) *cobra.Command {
+	t.Helper()
+	t.Cleanup(viper.Reset)
+
+	cmd := &cobra.Command{}
+	cmd.Flags().StringArray("subreddit", nil, "")
+	cmd.Flags().String("sort", defaultSort, "")
+	cmd.Flags().String("query", "", "")
+	return cmd
+}
+
+func TestDownloadArgsValidatesSearchSort(t *testing.T) {
+	cmd := newDownloadArgsCommand(t)
+	if err := cmd.Flags().Set("query", "nebula"); err != nil {
+		t.Fatalf("failed to set query flag: %v", err)
+	}
+
+	if err := cmd.Flags().Set("sort", "rising"); err != nil {
+		t.Fatalf("failed to set sort flag: %v", err)
+	}
+	if err := downloadCmd.Args(cmd, []string{"spaceporn", "month"}); err == nil {
+		t.Fatal("expected an error for rising sort with --query")
+	}
+
+	if err := cmd.Flags().Set("sort", "new"); err != nil {
+		t.Fatalf("failed to set sort flag: %v", err)
+	}
+	if err := downloadCmd.Args(cmd, []string{"spaceporn", "month"}); err != nil {
+		t.Fatalf("expected a period to be accepted with --query, got %v", err)
+	}
+}
+
+func TestDownloadArgsRejectsPeriodForUnsupportedSort(t *testing.T) {
+	cmd := newDownloadArgsCommand(t)
+	if err := cmd.Flags().Set("sort", "new"); err != nil {
+		t.Fatalf("failed to set sort flag: %v", err)
+	}
+
+	if err := downloadCmd.Args(cmd, []string{"wallpapers", "week"}); err == nil {
+		t.Fatal("expected an error when combining new sort with a period")
+	}
+	if err := downloadCmd.Args(cmd, []string{"wallpapers"}); err != nil {
+		t.Fatalf("expected no error without a period, got %v", err)
+	}
+}
+
+func TestDownloadArgsReadsSubredditFromConfig(t *testing.T) {
+	cmd := newDownloadArgsCommand(t)
+	if err := downloadCmd.Args(cmd, nil); err == nil {
+		t.Fatal("expected an error without any subreddit")
+	}
+
+	viper.Set("subreddit", []string{"wallpapers"})
+	if err := downloadCmd.Args(cmd, []string{"month"}); err != nil {
+		t.Fatalf("expected a configured subreddit to be accepted, got %v", err)
+	}
+}
+
 func TestImageExtension(t *testing.T) {
 	got := imageExtension("https://i.redd.it/test.png?width=1920&format=png")
 	if got != ".png" {
@@ -77,7 +171,7 @@ func TestSanitizeFilename(t *testing.T) {
 	}
 }
 
-func TestExtractCandidateImageURLs(t *testing.T) {
+func TestResolvePostCandidates(t *testing.T) {
 	post := models.Post{
 		Data: models.PostData{
 			Url:                 "https://i.redd.it/from-url.jpg",
@@ -90,11 +184,7 @@ func TestExtractCandidateImageURLs(t *testing.T) {
 			},
 			MediaMetadata: map[string]models.MediaMeta{
 				"media-1": {
-					S: struct {
-						U string `json:"u"`
-						X int    `json:"x"`
-						Y int    `json:"y"`
-					}{
+					S: models.MediaSource{
 						U: "https://i.redd.it/gallery.webp",
 					},
 				},
@@ -113,7 +203,10 @@ func TestExtractCandidateImageURLs(t *testing.T) {
 		},
 	}
 
-	got := extractCandidateImageURLs(post)
+	got, err := resolvePostCandidates(context.Background(), post)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
 	if len(got) != 3 {
 		t.Fatalf("expected 3 candidate URLs, got %d (%v)", len(got), got)
 	}
@@ -128,6 +221,40 @@ func TestExtractCandidateImageURLs(t *testing.T) {
 	}
 }
 
+func TestResolvePostCandidatesCarriesPostMetadata(t *testing.T) {
+	listing := `{"data": {"children": [{"kind": "t3", "data": {
+		"id": "abc", "title": "Lake", "author": "alice", "subreddit": "EarthPorn",
+		"score": 1234, "created_utc": 1709640000.0, "over_18": true, "spoiler": false,
+		"link_flair_text": "OC", "permalink": "/r/EarthPorn/comments/abc/lake/",
+		"num_comments": 56, "upvote_ratio": 0.97,
+		"url": "https://i.redd.it/lake.jpg"
+	}}]}}`
+
+	var response models.Response
+	if err := json.Unmarshal([]byte(listing), &response); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+
+	got, err := resolvePostCandidates(context.Background(), response.Data.Post[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].Post == nil {
+		t.Fatalf("expected 1 candidate carrying its post, got %+v", got)
+	}
+
+	post := got[0].Post
+	if post.Author != "alice" || post.Score != 1234 || !post.Over18 || post.Spoiler {
+		t.Fatalf("unexpected post metadata: %+v", post)
+	}
+	if post.LinkFlairText != "OC" || post.Permalink != "/r/EarthPorn/comments/abc/lake/" || post.NumComments != 56 || post.UpvoteRatio != 0.97 {
+		t.Fatalf("unexpected post metadata: %+v", post)
+	}
+	if want := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC); !post.Created().Equal(want) {
+		t.Fatalf("expected created time %v, got %v", want, post.Created())
+	}
+}
+
 func TestFilterCandidatesByResolution(t *testing.T) {
 	candidates := []imageCandidate{
 		{URL: "https://i.redd.it/a.jpg", Width: 1920, Height: 1080},
@@ -147,6 +274,53 @@ func TestFilterCandidatesByResolution(t *testing.T) {
 	}
 }
 
+func TestMatchesFilterSizeToleranceAndOrientation(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		filter        models.Filter
+		want          bool
+	}{
+		{"larger than minimum", 3840, 2160, models.Filter{MinWidth: 1920, MinHeight: 1080}, true},
+		{"smaller than minimum", 1280, 720, models.Filter{MinWidth: 1920, MinHeight: 1080}, false},
+		{"larger than maximum", 7680, 4320, models.Filter{MaxWidth: 3840, MaxHeight: 2160}, false},
+		{"exact ratio without tolerance", 2560, 1440, models.Filter{AspectRatioWidth: 16, AspectRatioHeight: 9}, true},
+		{"near ratio without tolerance", 2560, 1442, models.Filter{AspectRatioWidth: 16, AspectRatioHeight: 9}, false},
+		{"near ratio within tolerance", 2560, 1442, models.Filter{AspectRatioWidth: 16, AspectRatioHeight: 9, AspectTolerance: 1}, true},
+		{"ratio outside tolerance", 2560, 1600, models.Filter{AspectRatioWidth: 16, AspectRatioHeight: 9, AspectTolerance: 1}, false},
+		{"landscape", 1920, 1080, models.Filter{Orientation: models.OrientationLandscape}, true},
+		{"not portrait", 1920, 1080, models.Filter{Orientation: models.OrientationPortrait}, false},
+		{"square", 1080, 1080, models.Filter{Orientation: models.OrientationSquare}, true},
+	}
+
+	for _, tt := range tests {
+		candidate := imageCandidate{URL: "https://i.redd.it/a.jpg", Width: tt.width, Height: tt.height}
+		if got := matchesFilter(candidate, tt.filter); got != tt.want {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseSizeFilters(t *testing.T) {
+	filter, err := parseSizeFilters(models.Filter{}, "1920x1080", "3840x2160", 2.5, "Landscape")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if filter.MinWidth != 1920 || filter.MaxHeight != 2160 || filter.AspectTolerance != 2.5 || filter.Orientation != models.OrientationLandscape {
+		t.Fatalf("unexpected size filter: %+v", filter)
+	}
+
+	if _, err := parseSizeFilters(models.Filter{}, "3840x2160", "1920x1080", 0, ""); err == nil {
+		t.Fatal("expected an error when the minimum exceeds the maximum")
+	}
+	if _, err := parseSizeFilters(models.Filter{}, "", "", -1, ""); err == nil {
+		t.Fatal("expected an error for a negative tolerance")
+	}
+	if _, err := parseSizeFilters(models.Filter{}, "", "", 0, "diagonal"); err == nil {
+		t.Fatal("expected an error for an invalid orientation")
+	}
+}
+
 func TestGetTopWallpapersPaginatesAndHonorsLimit(t *testing.T) {
 	tmpDir := t.TempDir()
 	var serverURL string
@@ -205,7 +379,15 @@ func TestGetTopWallpapersPaginatesAndHonorsLimit(t *testing.T) {
 		httpClient = originalClient
 	}()
 
-	if err := getTopWallpapers(context.Background(), "test", "week", models.Filter{}, tmpDir, 3); err != nil {
+	err := getTopWallpapers(context.Background(), downloadOptions{
+		Subreddits:  []string{"test"},
+		Sort:        "top",
+		Period:      "week",
+		Location:    tmpDir,
+		Limit:       3,
+		Concurrency: 2,
+	})
+	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
@@ -227,3 +409,87 @@ func TestGetTopWallpapersPaginatesAndHonorsLimit(t *testing.T) {
 		t.Fatalf("expected third.jpg to exist: %v", err)
 	}
 }
+
+func TestParseSubreddits(t *testing.T) {
+	got, err := parseSubreddits([]string{"wallpapers+EarthPorn", "r/spaceporn", "earthporn"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want := []string{"wallpapers", "EarthPorn", "spaceporn"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if _, err := parseSubreddits([]string{"wall papers"}); err == nil {
+		t.Fatal("expected an error for an invalid subreddit name")
+	}
+}
+
+func TestGetTopWallpapersMultiSubredditUsesSubdirectoriesAndSharedDedupe(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/r/one+two/top.json", func(w http.ResponseWriter, r *http.Request) {
+		out := models.Response{}
+		out.Data.Post = []models.Post{
+			{Data: models.PostData{Title: "shared", Subreddit: "one", URLOverriddenByDest: serverURL + "/img/shared.jpg"}},
+			{Data: models.PostData{Title: "crosspost", Subreddit: "two", URLOverriddenByDest: serverURL + "/img/shared.jpg"}},
+			{Data: models.PostData{Title: "own", Subreddit: "two", URLOverriddenByDest: serverURL + "/img/own.jpg"}},
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	err := getTopWallpapers(context.Background(), downloadOptions{
+		Subreddits:  []string{"one", "two"},
+		Sort:        "top",
+		Period:      "week",
+		Location:    tmpDir,
+		Limit:       10,
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, name := range []string{filepath.Join("one", "shared.jpg"), filepath.Join("two", "own.jpg")} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "two", "crosspost.jpg")); err == nil {
+		t.Fatal("expected crosspost with a duplicate URL to be skipped")
+	}
+}
+
+func TestPrintOrderedWritesInSequence(t *testing.T) {
+	results := make(chan downloadResult, 3)
+	results <- downloadResult{Seq: 2, Output: "c\n"}
+	results <- downloadResult{Seq: 0, Output: "a\n"}
+	results <- downloadResult{Seq: 1, Output: "b\n"}
+	close(results)
+
+	var out bytes.Buffer
+	printOrdered(&out, results)
+
+	if got, want := out.String(), "a\nb\nc\n"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
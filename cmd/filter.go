@@ -0,0 +1,62 @@
+package cmd
+
+// candidateFilter selects the image candidates that are downloaded.
+// models.Filter implements it for the resolution and aspect ratio flags, and
+// whereFilter for --where expressions.
+type candidateFilter interface {
+	Matches(candidate imageCandidate) bool
+}
+
+// allFilters matches a candidate that every one of its filters matches.
+type allFilters []candidateFilter
+
+func (filters allFilters) Matches(candidate imageCandidate) bool {
+	for _, filter := range filters {
+		if !matchesFilter(candidate, filter) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unknownSizeFilter passes candidates whose size Reddit did not report if
+// they can still match once they download and their size is checked.
+// Candidates that cannot be verified, such as videos, must match the filter
+// like any other.
+type unknownSizeFilter struct {
+	filter candidateFilter
+}
+
+func (f unknownSizeFilter) Matches(candidate imageCandidate) bool {
+	if (candidate.Width <= 0 || candidate.Height <= 0) && canVerifyDimensions(candidate) {
+		return matchesUnknownSize(candidate, f.filter)
+	}
+
+	return matchesFilter(candidate, f.filter)
+}
+
+// matchesUnknownSize reports whether candidate can match filter once its
+// size is known, checking everything that does not depend on the size.
+func matchesUnknownSize(candidate imageCandidate, filter candidateFilter) bool {
+	switch filter := filter.(type) {
+	case allFilters:
+		for _, f := range filter {
+			if !matchesUnknownSize(candidate, f) {
+				return false
+			}
+		}
+		return true
+	case *whereFilter:
+		return filter.MatchesUnknownSize(candidate)
+	}
+
+	// models.Filter only looks at the size.
+	return true
+}
+
+// canVerifyDimensions reports whether the size of candidate can be decoded
+// from the start of its download, which only works for still image formats.
+func canVerifyDimensions(candidate imageCandidate) bool {
+	return candidate.DashURL == "" && hasSupportedImageExtension(candidate.URL)
+}
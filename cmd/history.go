@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// historyEntry is a single completed download, stored as one JSON line.
+// Gallery images also keep their caption and outbound link.
+type historyEntry struct {
+	PostID       string    `json:"post_id"`
+	URL          string    `json:"url"`
+	Path         string    `json:"path"`
+	Caption      string    `json:"caption,omitempty"`
+	OutboundURL  string    `json:"outbound_url,omitempty"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// historyStore is an append-only JSON-lines log of completed downloads keyed
+// by post ID and media URL. It is safe for concurrent use, and a nil store
+// records nothing and has seen nothing.
+type historyStore struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]historyEntry
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List or prune the download history",
+	Long: `history - inspect the record of downloaded posts that download and user
+	consult to skip posts they have already saved.`,
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List downloaded posts",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		history, err := openDefaultHistory()
+		if err != nil {
+			return err
+		}
+
+		postID, _ := cmd.Flags().GetString("post")
+		for _, entry := range history.list() {
+			if postID != "" && entry.PostID != postID {
+				continue
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\t%s\t%s\n", entry.DownloadedAt.Format(time.RFC3339), entry.PostID, entry.URL, entry.Path, entry.Caption)
+		}
+
+		return nil
+	},
+}
+
+var historyPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove entries from the download history",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		olderThan, _ := cmd.Flags().GetString("older-than")
+		postID, _ := cmd.Flags().GetString("post")
+		missing, _ := cmd.Flags().GetBool("missing")
+		all, _ := cmd.Flags().GetBool("all")
+		if olderThan == "" && postID == "" && !missing && !all {
+			return errors.New("one of --older-than, --post, --missing or --all is required")
+		}
+
+		var cutoff time.Time
+		if olderThan != "" {
+			age, err := parseAge(olderThan)
+			if err != nil {
+				return err
+			}
+			cutoff = time.Now().Add(-age)
+		}
+
+		history, err := openDefaultHistory()
+		if err != nil {
+			return err
+		}
+
+		removed, err := history.prune(func(entry historyEntry) bool {
+			if all {
+				return true
+			}
+			if postID != "" && entry.PostID != postID {
+				return false
+			}
+			if !cutoff.IsZero() && !entry.DownloadedAt.Before(cutoff) {
+				return false
+			}
+			if missing {
+				if _, err := os.Stat(entry.Path); err == nil {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), removed, "history entries removed.")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyListCmd)
+	historyCmd.AddCommand(historyPruneCmd)
+	historyListCmd.Flags().String("post", "", "only list entries for this post ID")
+	historyPruneCmd.Flags().String("older-than", "", "remove entries older than this age (i.e. 720h or 30d)")
+	historyPruneCmd.Flags().String("post", "", "remove entries for this post ID")
+	historyPruneCmd.Flags().Bool("missing", false, "remove entries whose file no longer exists")
+	historyPruneCmd.Flags().Bool("all", false, "remove every entry")
+}
+
+// historyPath returns the location of the download history file inside the
+// user's config directory.
+func historyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "snoo-dl", "history.jsonl"), nil
+}
+
+func openDefaultHistory() (*historyStore, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return openHistory(path)
+}
+
+// openHistory loads the history at path. A missing file is an empty history.
+func openHistory(path string) (*historyStore, error) {
+	history := &historyStore{
+		path:    path,
+		entries: make(map[string]historyEntry),
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid history entry at %s:%d - %w", path, line, err)
+		}
+		history.entries[historyKey(entry.PostID, entry.URL)] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func historyKey(postID string, mediaURL string) string {
+	return postID + " " + mediaURL
+}
+
+func (h *historyStore) seen(postID string, mediaURL string) bool {
+	if h == nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.entries[historyKey(postID, mediaURL)]
+	return ok
+}
+
+// unseen returns the candidates of postID that are not in the history.
+func (h *historyStore) unseen(postID string, candidates []imageCandidate) []imageCandidate {
+	if h == nil {
+		return candidates
+	}
+
+	out := make([]imageCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if h.seen(postID, candidate.URL) {
+			continue
+		}
+		out = append(out, candidate)
+	}
+
+	return out
+}
+
+// record appends a completed download to the history file, stamping it
+// with the current time.
+func (h *historyStore) record(entry historyEntry) error {
+	if h == nil {
+		return nil
+	}
+
+	entry.DownloadedAt = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(h.path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	h.entries[historyKey(entry.PostID, entry.URL)] = entry
+	return nil
+}
+
+// list returns every entry, oldest first.
+func (h *historyStore) list() []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make([]historyEntry, 0, len(h.entries))
+	for _, entry := range h.entries {
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].DownloadedAt.Before(out[j].DownloadedAt)
+	})
+
+	return out
+}
+
+// prune removes every entry for which remove returns true and rewrites the
+// history file. It returns the number of entries removed.
+func (h *historyStore) prune(remove func(historyEntry) bool) (int, error) {
+	kept := make([]historyEntry, 0)
+	removed := 0
+	for _, entry := range h.list() {
+		if remove(entry) {
+			removed++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tmpPath := h.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range kept {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return 0, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, h.path); err != nil {
+		return 0, err
+	}
+
+	h.entries = make(map[string]historyEntry, len(kept))
+	for _, entry := range kept {
+		h.entries[historyKey(entry.PostID, entry.URL)] = entry
+	}
+
+	return removed, nil
+}
+
+// parseAge parses a Go duration, additionally accepting a whole number of
+// days such as "30d".
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+
+	return age, nil
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+func TestHistoryStoreRecordAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "history.jsonl")
+	history, err := openHistory(path)
+	if err != nil {
+		t.Fatalf("expected no error opening a missing history, got %v", err)
+	}
+
+	if err := history.record(historyEntry{PostID: "abc", URL: "https://i.redd.it/a.jpg", Path: "/tmp/a.jpg"}); err != nil {
+		t.Fatalf("expected no error recording, got %v", err)
+	}
+
+	reloaded, err := openHistory(path)
+	if err != nil {
+		t.Fatalf("expected no error reloading, got %v", err)
+	}
+	if !reloaded.seen("abc", "https://i.redd.it/a.jpg") {
+		t.Fatal("expected recorded entry to be seen after reload")
+	}
+	if reloaded.seen("other", "https://i.redd.it/a.jpg") {
+		t.Fatal("did not expect a different post ID to be seen")
+	}
+
+	candidates := []imageCandidate{
+		{URL: "https://i.redd.it/a.jpg"},
+		{URL: "https://i.redd.it/b.jpg"},
+	}
+	unseen := reloaded.unseen("abc", candidates)
+	if len(unseen) != 1 || unseen[0].URL != "https://i.redd.it/b.jpg" {
+		t.Fatalf("unexpected unseen candidates: %v", unseen)
+	}
+}
+
+func TestHistoryStorePrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := openHistory(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := history.record(historyEntry{PostID: id, URL: "https://i.redd.it/" + id + ".jpg"}); err != nil {
+			t.Fatalf("expected no error recording, got %v", err)
+		}
+	}
+
+	removed, err := history.prune(func(entry historyEntry) bool { return entry.PostID == "b" })
+	if err != nil {
+		t.Fatalf("expected no error pruning, got %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 entry removed, got %d", removed)
+	}
+
+	reloaded, err := openHistory(path)
+	if err != nil {
+		t.Fatalf("expected no error reloading, got %v", err)
+	}
+	if len(reloaded.list()) != 2 || reloaded.seen("b", "https://i.redd.it/b.jpg") {
+		t.Fatalf("unexpected entries after prune: %v", reloaded.list())
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	got, err := parseAge("30d")
+	if err != nil || got != 30*24*time.Hour {
+		t.Fatalf("expected 720h, got %v (%v)", got, err)
+	}
+
+	if _, err := parseAge("soon"); err == nil {
+		t.Fatal("expected an error for an invalid age")
+	}
+}
+
+func TestGetTopWallpapersSkipsPostsInHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/r/test/top.json", func(w http.ResponseWriter, r *http.Request) {
+		out := models.Response{}
+		out.Data.Post = []models.Post{
+			{Data: models.PostData{ID: "abc", Title: "first", URLOverriddenByDest: serverURL + "/img/first.jpg"}},
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	history, err := openHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	opts := downloadOptions{
+		Subreddits:  []string{"test"},
+		Sort:        "top",
+		Period:      "week",
+		Location:    tmpDir,
+		Limit:       1,
+		Concurrency: 1,
+		History:     history,
+	}
+
+	if err := getTopWallpapers(context.Background(), opts); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	path := filepath.Join(tmpDir, "first.jpg")
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("expected first.jpg to have been downloaded: %v", err)
+	}
+
+	if err := getTopWallpapers(context.Background(), opts); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("expected post in history to be skipped")
+	}
+
+	opts.IgnoreHistory = true
+	if err := getTopWallpapers(context.Background(), opts); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected --ignore-history to download again: %v", err)
+	}
+}
+
+func TestGetTopWallpapersRecordsGalleryCaptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/r/test/top.json", func(w http.ResponseWriter, r *http.Request) {
+		post := models.PostData{
+			ID:        "abc",
+			Title:     "alps",
+			Url:       "https://www.reddit.com/gallery/abc",
+			IsGallery: true,
+			GalleryData: models.GalleryData{Items: []models.GalleryItem{
+				{MediaID: "one", Caption: "Dawn &amp; mist", OutboundURL: "https://example.com/prints/1"},
+				{MediaID: "two"},
+			}},
+			MediaMetadata: map[string]models.MediaMeta{
+				"one": {S: models.MediaSource{U: serverURL + "/img/one.jpg"}},
+				"two": {S: models.MediaSource{U: serverURL + "/img/two.jpg"}},
+			},
+		}
+		out := models.Response{}
+		out.Data.Post = []models.Post{{Data: post}}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	historyFile := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := openHistory(historyFile)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = getTopWallpapers(context.Background(), downloadOptions{
+		Subreddits:  []string{"test"},
+		Sort:        "top",
+		Period:      "week",
+		Location:    tmpDir,
+		Limit:       1,
+		Concurrency: 1,
+		History:     history,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	reloaded, err := openHistory(historyFile)
+	if err != nil {
+		t.Fatalf("expected no error reloading, got %v", err)
+	}
+	captions := make(map[string]historyEntry)
+	for _, entry := range reloaded.list() {
+		captions[entry.URL] = entry
+	}
+	first := captions[server.URL+"/img/one.jpg"]
+	if first.Caption != "Dawn & mist" || first.OutboundURL != "https://example.com/prints/1" || first.Path != filepath.Join(tmpDir, "alps.jpg") {
+		t.Fatalf("expected the caption and outbound link of the first image, got %+v", first)
+	}
+	if second, ok := captions[server.URL+"/img/two.jpg"]; !ok || second.Caption != "" {
+		t.Fatalf("expected the second image without a caption, got %+v", second)
+	}
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/viper"
+)
+
+var (
+	imgurAPIURL = "https://api.imgur.com/3"
+
+	// Imgur IDs are 5 (older) or 7 characters long, which also tells them
+	// apart from pages such as /upload or /signin.
+	validImgurID = regexp.MustCompile(`^(?:[A-Za-z0-9]{5}|[A-Za-z0-9]{7})$`)
+
+	errImgurClientID = errors.New("imgur albums need an API client ID, set imgur.client_id in the config file")
+)
+
+func init() {
+	registerResolver(imgurResolver{})
+}
+
+// imgurResolver resolves Imgur image pages, albums and galleries.
+type imgurResolver struct{}
+
+func (imgurResolver) Match(link *url.URL) bool {
+	_, ok := parseImgurLink(link.String())
+	return ok
+}
+
+// Resolve returns the images behind link. A single image resolved without
+// an API client ID takes the size of the post preview.
+func (imgurResolver) Resolve(ctx context.Context, link *url.URL, post *models.PostData) ([]imageCandidate, error) {
+	imgur, _ := parseImgurLink(link.String())
+	candidates, err := resolveImgur(ctx, imgur)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 1 && candidates[0].Width == 0 {
+		candidates[0].Width, candidates[0].Height = previewSize(post)
+	}
+
+	return candidates, nil
+}
+
+// imgurImage is an image in a response of the Imgur API.
+type imgurImage struct {
+	Link     string `json:"link"`
+	MP4      string `json:"mp4"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Animated bool   `json:"animated"`
+}
+
+// imgurLink is a post URL on imgur.com. Kind is image, album, gallery or
+// animated, the latter for .gifv and .mp4 links.
+type imgurLink struct {
+	Kind string
+	ID   string
+}
+
+// parseImgurLink recognizes Imgur image pages, albums, galleries and
+// animations. Direct i.imgur.com links with an image extension are not Imgur
+// links, since they can be downloaded as they are.
+func parseImgurLink(rawURL string) (imgurLink, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return imgurLink{}, false
+	}
+
+	switch strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.") {
+	case "imgur.com", "m.imgur.com", "i.imgur.com":
+	default:
+		return imgurLink{}, false
+	}
+
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	link := imgurLink{Kind: "image"}
+	switch {
+	case len(segments) == 2 && segments[0] == "a":
+		link = imgurLink{Kind: "album", ID: segments[1]}
+	case len(segments) == 2 && segments[0] == "gallery":
+		link = imgurLink{Kind: "gallery", ID: segments[1]}
+	case len(segments) == 3 && segments[0] == "t":
+		link = imgurLink{Kind: "gallery", ID: segments[2]}
+	case len(segments) == 1:
+		ext := path.Ext(segments[0])
+		switch strings.ToLower(ext) {
+		case "":
+		case ".gifv", ".mp4":
+			link.Kind = "animated"
+		default:
+			// Direct images are downloaded as they are.
+			return imgurLink{}, false
+		}
+		link.ID = strings.TrimSuffix(segments[0], ext)
+	default:
+		return imgurLink{}, false
+	}
+
+	// Newer album and gallery URLs carry a title slug: /a/some-title-abc123.
+	if i := strings.LastIndexByte(link.ID, '-'); i >= 0 {
+		link.ID = link.ID[i+1:]
+	}
+	if !validImgurID.MatchString(link.ID) {
+		return imgurLink{}, false
+	}
+
+	return link, true
+}
+
+// resolveImgur returns the images behind an Imgur link. A single image or
+// animation can be resolved without an API client ID, though its size is
+// then unknown.
+func resolveImgur(ctx context.Context, link imgurLink) ([]imageCandidate, error) {
+	if link.Kind == "animated" {
+		mp4 := "https://i.imgur.com/" + link.ID + ".mp4"
+		return []imageCandidate{{URL: mp4, MP4URL: mp4, GIFURL: "https://i.imgur.com/" + link.ID + ".gif"}}, nil
+	}
+
+	clientID := viper.GetString("imgur.client_id")
+	if clientID == "" {
+		if link.Kind != "image" {
+			return nil, errImgurClientID
+		}
+		return []imageCandidate{{URL: "https://i.imgur.com/" + link.ID + ".jpg"}}, nil
+	}
+
+	var images []imgurImage
+	switch link.Kind {
+	case "image":
+		var image imgurImage
+		if err := fetchImgur(ctx, clientID, "/image/"+link.ID, &image); err != nil {
+			return nil, err
+		}
+		images = []imgurImage{image}
+	case "album":
+		var album struct {
+			Images []imgurImage `json:"images"`
+		}
+		if err := fetchImgur(ctx, clientID, "/album/"+link.ID, &album); err != nil {
+			return nil, err
+		}
+		images = album.Images
+	default:
+		var gallery struct {
+			imgurImage
+			IsAlbum bool         `json:"is_album"`
+			Images  []imgurImage `json:"images"`
+		}
+		if err := fetchImgur(ctx, clientID, "/gallery/"+link.ID, &gallery); err != nil {
+			return nil, err
+		}
+		images = gallery.Images
+		if !gallery.IsAlbum {
+			images = []imgurImage{gallery.imgurImage}
+		}
+	}
+
+	candidates := make([]imageCandidate, 0, len(images))
+	for _, image := range images {
+		candidate := imageCandidate{URL: image.Link, Width: image.Width, Height: image.Height}
+		if image.Animated {
+			// Animations link to their GIF form, if they have one.
+			if imageExtension(image.Link) == ".gif" {
+				candidate.GIFURL = image.Link
+			}
+			candidate.MP4URL = image.MP4
+			if candidate.MP4URL != "" {
+				candidate.URL = candidate.MP4URL
+			}
+		}
+		if candidate.MP4URL == "" && !hasSupportedImageExtension(candidate.URL) {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// fetchImgur decodes the data field of an Imgur API response into v.
+func fetchImgur(ctx context.Context, clientID string, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imgurAPIURL+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Client-ID "+clientID)
+	req.Header.Set("User-agent", userAgent)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return fmt.Errorf("error while requesting imgur %s - %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("imgur request "+endpoint, resp)
+	}
+
+	body := struct {
+		Data any `json:"data"`
+	}{Data: v}
+	return json.NewDecoder(resp.Body).Decode(&body)
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/viper"
+)
+
+func TestParseImgurLink(t *testing.T) {
+	tests := []struct {
+		url  string
+		want imgurLink
+		ok   bool
+	}{
+		{"https://imgur.com/AbCdE12", imgurLink{Kind: "image", ID: "AbCdE12"}, true},
+		{"https://m.imgur.com/AbCdE12/", imgurLink{Kind: "image", ID: "AbCdE12"}, true},
+		{"https://imgur.com/a/xYz89", imgurLink{Kind: "album", ID: "xYz89"}, true},
+		{"https://imgur.com/a/mountain-lake-at-dawn-xYz89", imgurLink{Kind: "album", ID: "xYz89"}, true},
+		{"http://www.imgur.com/gallery/GaL1234", imgurLink{Kind: "gallery", ID: "GaL1234"}, true},
+		{"https://imgur.com/t/wallpaper/GaL1234", imgurLink{Kind: "gallery", ID: "GaL1234"}, true},
+		{"https://i.imgur.com/AbCdE12.jpg", imgurLink{}, false},
+		{"https://i.imgur.com/AbCdE12.gifv", imgurLink{Kind: "animated", ID: "AbCdE12"}, true},
+		{"https://i.imgur.com/AbCdE12.mp4", imgurLink{Kind: "animated", ID: "AbCdE12"}, true},
+		{"https://imgur.com/upload", imgurLink{}, false},
+		{"https://example.com/a/xYz89", imgurLink{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseImgurLink(tt.url)
+		if ok != tt.ok || got != tt.want {
+			t.Fatalf("%s: expected %+v (%v), got %+v (%v)", tt.url, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+// newImgurTestServer stands in for the Imgur API, serving an image, an album
+// and a gallery album. Image links point back at the server.
+func newImgurTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/3/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Client-ID test-client" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var data string
+		switch r.URL.Path {
+		case "/3/image/AbCdE12":
+			data = `{"link":"` + server.URL + `/img/AbCdE12.png","width":3840,"height":2160}`
+		case "/3/album/xYz89":
+			data = `{"images":[
+				{"link":"` + server.URL + `/img/one.jpg","width":2560,"height":1440},
+				{"link":"` + server.URL + `/img/clip.gif","mp4":"` + server.URL + `/img/clip.mp4","width":1920,"height":1080,"animated":true},
+				{"link":"` + server.URL + `/img/two.jpg","width":1920,"height":1080}
+			]}`
+		case "/3/gallery/GaL1234":
+			data = `{"is_album":true,"images":[{"link":"` + server.URL + `/img/three.jpg","width":1080,"height":1920}]}`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"status":200,"data":` + data + `}`))
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	originalAPIURL := imgurAPIURL
+	originalClient := httpClient
+	imgurAPIURL = server.URL + "/3"
+	httpClient = server.Client()
+	t.Cleanup(func() {
+		imgurAPIURL = originalAPIURL
+		httpClient = originalClient
+	})
+
+	return server
+}
+
+func TestResolveImgur(t *testing.T) {
+	server := newImgurTestServer(t)
+	t.Cleanup(viper.Reset)
+	viper.Set("imgur.client_id", "test-client")
+
+	tests := []struct {
+		link imgurLink
+		want []imageCandidate
+	}{
+		{imgurLink{Kind: "image", ID: "AbCdE12"}, []imageCandidate{{URL: server.URL + "/img/AbCdE12.png", Width: 3840, Height: 2160}}},
+		{imgurLink{Kind: "album", ID: "xYz89"}, []imageCandidate{
+			{URL: server.URL + "/img/one.jpg", Width: 2560, Height: 1440},
+			{URL: server.URL + "/img/clip.mp4", Width: 1920, Height: 1080, MP4URL: server.URL + "/img/clip.mp4", GIFURL: server.URL + "/img/clip.gif"},
+			{URL: server.URL + "/img/two.jpg", Width: 1920, Height: 1080},
+		}},
+		{imgurLink{Kind: "gallery", ID: "GaL1234"}, []imageCandidate{{URL: server.URL + "/img/three.jpg", Width: 1080, Height: 1920}}},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveImgur(context.Background(), tt.link)
+		if err != nil {
+			t.Fatalf("%+v: expected no error, got %v", tt.link, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%+v: expected %+v, got %+v", tt.link, tt.want, got)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%+v: expected %+v, got %+v", tt.link, tt.want[i], got[i])
+			}
+		}
+	}
+
+	if _, err := resolveImgur(context.Background(), imgurLink{Kind: "album", ID: "missing"}); err == nil {
+		t.Fatal("expected an error for a missing album")
+	}
+}
+
+func TestResolveImgurWithoutClientID(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	got, err := resolveImgur(context.Background(), imgurLink{Kind: "image", ID: "AbCdE12"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].URL != "https://i.imgur.com/AbCdE12.jpg" {
+		t.Fatalf("expected a direct i.imgur.com link, got %+v", got)
+	}
+
+	if _, err := resolveImgur(context.Background(), imgurLink{Kind: "album", ID: "xYz89"}); err == nil {
+		t.Fatal("expected an error for an album without a client ID")
+	}
+}
+
+func TestResolvePostCandidatesUsesPreviewSizeForImgur(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	post := models.Post{Data: models.PostData{ID: "abc", Url: "https://imgur.com/AbCdE12"}}
+	post.Data.Preview.Images = []models.PreviewImage{{Source: models.ImageSource{Width: 2560, Height: 1440}}}
+
+	got, err := resolvePostCandidates(context.Background(), post)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].Width != 2560 || got[0].Height != 1440 || got[0].Post == nil || got[0].Post.ID != "abc" {
+		t.Fatalf("expected the preview size and post to be set, got %+v", got)
+	}
+}
+
+func TestGetTopWallpapersDownloadsImgurAlbum(t *testing.T) {
+	newImgurTestServer(t)
+	t.Cleanup(viper.Reset)
+	viper.Set("imgur.client_id", "test-client")
+
+	tmpDir := t.TempDir()
+	listing := http.NewServeMux()
+	listing.HandleFunc("/r/wallpapers/top.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"children":[
+			{"data":{"id":"abc","title":"album","url":"https://imgur.com/a/xYz89"}},
+			{"data":{"id":"def","title":"private","url":"https://imgur.com/a/missing"}}
+		]}}`))
+	})
+	listingServer := httptest.NewServer(listing)
+	defer listingServer.Close()
+
+	originalRedditURL := redditURL
+	redditURL = listingServer.URL + "/r"
+	defer func() { redditURL = originalRedditURL }()
+
+	err := getTopWallpapers(context.Background(), downloadOptions{
+		Subreddits:  []string{"wallpapers"},
+		Sort:        "top",
+		Period:      "week",
+		Filter:      models.Filter{MinWidth: 2000},
+		Location:    tmpDir,
+		Limit:       10,
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "album.jpg")); err != nil {
+		t.Fatalf("expected album.jpg to exist: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "album_2.jpg")); err == nil {
+		t.Fatal("expected the album image below the minimum width to be filtered")
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// nameFields are the fields available to a --name-template.
+type nameFields struct {
+	Title     string
+	Subreddit string
+	ID        string
+	Author    string
+	Created   time.Time
+
+	Score       int
+	Flair       string
+	NSFW        bool
+	Spoiler     bool
+	NumComments int
+	UpvoteRatio float64
+
+	// Index is the 1-based position of the image in a gallery, and 1 for a
+	// single image post. Caption and OutboundURL are those of the gallery
+	// item, and empty for other images.
+	Index       int
+	Caption     string
+	OutboundURL string
+
+	// Width and Height are the dimensions reported by Reddit, or 0 when they
+	// are unknown.
+	Width  int
+	Height int
+}
+
+var nameTemplateFuncs = template.FuncMap{
+	// date formats a time with a Go reference layout, so it can be used as
+	// {{.Created | date "2006-01-02"}}.
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"lower": strings.ToLower,
+}
+
+// parseNameTemplate parses a --name-template. It is executed once against
+// sample fields so that unknown fields are reported up front rather than for
+// every download.
+func parseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Funcs(nameTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template - %w", err)
+	}
+
+	sample := nameFields{Title: "title", Subreddit: "wallpapers", ID: "abc123", Author: "someone", Created: time.Unix(0, 0).UTC(), Index: 1}
+	if _, err := renderName(tmpl, sample); err != nil {
+		return nil, fmt.Errorf("invalid name template - %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// parseOptionalNameTemplate parses text unless it is empty, in which case
+// files are named after post titles.
+func parseOptionalNameTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	return parseNameTemplate(text)
+}
+
+// postNameFields returns the template fields of candidate, the index-th
+// image of its post.
+func postNameFields(candidate imageCandidate, index int) nameFields {
+	fields := nameFields{
+		Index:       index,
+		Caption:     candidate.Caption,
+		OutboundURL: candidate.OutboundURL,
+		Width:       candidate.Width,
+		Height:      candidate.Height,
+	}
+	if post := candidate.Post; post != nil {
+		fields.Title = post.Title
+		fields.Subreddit = post.Subreddit
+		fields.ID = post.ID
+		fields.Author = post.Author
+		fields.Created = post.Created()
+		fields.Score = post.Score
+		fields.Flair = post.LinkFlairText
+		fields.NSFW = post.Over18
+		fields.Spoiler = post.Spoiler
+		fields.NumComments = post.NumComments
+		fields.UpvoteRatio = post.UpvoteRatio
+	}
+
+	return fields
+}
+
+// renderName executes tmpl and returns a slash separated path, relative to
+// the download location, with every segment sanitized.
+func renderName(tmpl *template.Template, fields nameFields) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, fields); err != nil {
+		return "", err
+	}
+
+	return sanitizeFilePath(b.String()), nil
+}
+
+// sanitizeFilePath sanitizes every segment of a slash separated path. Empty,
+// "." and ".." segments are dropped so the result never leaves the download
+// location.
+func sanitizeFilePath(name string) string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(name, "/") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, sanitizeFilename(segment))
+	}
+	if len(segments) == 0 {
+		return sanitizeFilename("")
+	}
+
+	return path.Join(segments...)
+}
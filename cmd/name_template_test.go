@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+func TestSanitizeFilePath(t *testing.T) {
+	got := sanitizeFilePath("../wallpapers/ My Title: 4K? //./2024")
+	want := "wallpapers/My_Title__4K/2024"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if got := sanitizeFilePath("/../"); got != "reddit_image" {
+		t.Fatalf("expected reddit_image for an empty path, got %q", got)
+	}
+}
+
+func TestRenderName(t *testing.T) {
+	tmpl, err := parseNameTemplate(`{{.Subreddit | lower}}/{{.Created | date "2006-01"}}/{{.ID}}_{{.Index}}_{{.Width}}x{{.Height}}`)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	got, err := renderName(tmpl, nameFields{
+		Subreddit: "EarthPorn",
+		ID:        "abc",
+		Created:   time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC),
+		Index:     2,
+		Width:     3840,
+		Height:    2160,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if want := "earthporn/2024-03/abc_2_3840x2160"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestParseNameTemplateRejectsUnknownFields(t *testing.T) {
+	if _, err := parseNameTemplate("{{.Flare}}"); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+	if _, err := parseNameTemplate("{{.ID"); err == nil {
+		t.Fatal("expected an error for an unterminated action")
+	}
+}
+
+func TestGetTopWallpapersUsesNameTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/r/one+two/top.json", func(w http.ResponseWriter, r *http.Request) {
+		out := models.Response{}
+		out.Data.Post = []models.Post{
+			{Data: models.PostData{ID: "abc", Title: "same title", Subreddit: "one", Author: "alice", URLOverriddenByDest: serverURL + "/img/one.jpg"}},
+			{Data: models.PostData{ID: "def", Title: "same title", Subreddit: "two", Author: "bob", URLOverriddenByDest: serverURL + "/img/two.png"}},
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	tmpl, err := parseNameTemplate("{{.Author}}/{{.Subreddit}}_{{.ID}}")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = getTopWallpapers(context.Background(), downloadOptions{
+		Subreddits:   []string{"one", "two"},
+		Sort:         "top",
+		Period:       "week",
+		Location:     tmpDir,
+		Limit:        10,
+		Concurrency:  2,
+		NameTemplate: tmpl,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, name := range []string{filepath.Join("alice", "one_abc.jpg"), filepath.Join("bob", "two_def.png")} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+	}
+}
+
+func TestGetTopWallpapersNamesGalleryItemsByPosition(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/r/earthporn/top.json", func(w http.ResponseWriter, r *http.Request) {
+		post := models.Post{Data: models.PostData{
+			ID:        "abc",
+			Title:     "Alps",
+			Url:       "https://www.reddit.com/gallery/abc",
+			IsGallery: true,
+			GalleryData: models.GalleryData{Items: []models.GalleryItem{
+				{MediaID: "small", Caption: "Thumbnail"},
+				{MediaID: "dawn", Caption: "Dawn"},
+				{MediaID: "dusk", Caption: "Dusk"},
+			}},
+			MediaMetadata: map[string]models.MediaMeta{
+				"small": {Status: "valid", S: models.MediaSource{U: serverURL + "/img/small.jpg", X: 640, Y: 480}},
+				"dawn":  {Status: "valid", S: models.MediaSource{U: serverURL + "/img/dawn.jpg", X: 3840, Y: 2160}},
+				"dusk":  {Status: "valid", S: models.MediaSource{U: serverURL + "/img/dusk.png", X: 3840, Y: 2160}},
+			},
+		}}
+		out := models.Response{}
+		out.Data.Post = []models.Post{post}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	opts := downloadOptions{
+		Subreddits:  []string{"earthporn"},
+		Sort:        "top",
+		Period:      "week",
+		Filter:      models.Filter{MinWidth: 1920},
+		Location:    tmpDir,
+		Limit:       10,
+		Concurrency: 2,
+	}
+	if err := getTopWallpapers(context.Background(), opts); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, name := range []string{"Alps_2.jpg", "Alps_3.png"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "Alps.jpg")); err == nil {
+		t.Fatal("expected the filtered first item not to shift the names")
+	}
+
+	opts.Location = t.TempDir()
+	var err error
+	if opts.NameTemplate, err = parseNameTemplate("{{.ID}}_{{.Index}}_{{.Caption}}"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if opts.Where, err = parseWhere("caption ~ '^dusk'"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := getTopWallpapers(context.Background(), opts); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	entries, err := os.ReadDir(opts.Location)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", opts.Location, err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "abc_3_Dusk.png" {
+		t.Fatalf("expected only abc_3_Dusk.png, got %v", entries)
+	}
+}
+
+func TestGetTopWallpapersNumbersCollidingTemplateNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+	mux.HandleFunc("/r/test/top.json", func(w http.ResponseWriter, r *http.Request) {
+		post := models.Post{Data: models.PostData{
+			ID:        "abc",
+			Title:     "Alps",
+			Url:       "https://www.reddit.com/gallery/abc",
+			IsGallery: true,
+			GalleryData: models.GalleryData{Items: []models.GalleryItem{
+				{MediaID: "one"}, {MediaID: "two"}, {MediaID: "three"},
+			}},
+			MediaMetadata: map[string]models.MediaMeta{
+				"one":   {S: models.MediaSource{U: serverURL + "/img/one.jpg"}},
+				"two":   {S: models.MediaSource{U: serverURL + "/img/two.jpg"}},
+				"three": {S: models.MediaSource{U: serverURL + "/img/three.png"}},
+			},
+		}}
+		out := models.Response{}
+		out.Data.Post = []models.Post{post}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	tmpl, err := parseNameTemplate("{{.ID}}")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = getTopWallpapers(context.Background(), downloadOptions{
+		Subreddits:   []string{"test"},
+		Sort:         "top",
+		Period:       "week",
+		Location:     tmpDir,
+		Limit:        1,
+		Concurrency:  2,
+		NameTemplate: tmpl,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for name, want := range map[string]string{"abc.jpg": "/img/one.jpg", "abc_2.jpg": "/img/two.jpg", "abc.png": "/img/three.png"} {
+		got, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil || string(got) != want {
+			t.Fatalf("expected %s to hold %s, got %q (%v)", name, want, got, err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	redditOAuthURL     = "https://oauth.reddit.com"
+	redditTokenURL     = "https://www.reddit.com/api/v1/access_token"
+	redditAuthorizeURL = "https://www.reddit.com/api/v1/authorize"
+
+	userAgent = "snoo-dl/0.1"
+
+	defaultRedirectURI = "http://localhost:65010/callback"
+	oauthScopes        = "identity read history mysubreddits"
+
+	// redditAuth authenticates listing requests when credentials are
+	// configured. It is nil for anonymous access.
+	redditAuth *redditAuthenticator
+)
+
+// authConfig holds the OAuth2 credentials read from the auth section of the
+// config file. A script app sets Username and Password; an installed app
+// leaves ClientSecret empty and uses a refresh token from "auth login".
+type authConfig struct {
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	RefreshToken string
+	RedirectURI  string
+}
+
+// oauthToken is the token persisted between runs.
+type oauthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// redditAuthenticator hands out access tokens, refreshing them shortly before
+// they expire. It is safe for concurrent use.
+type redditAuthenticator struct {
+	config    authConfig
+	tokenPath string
+
+	mu    sync.Mutex
+	token oauthToken
+}
+
+func loadAuthConfig() authConfig {
+	config := authConfig{
+		ClientID:     viper.GetString("auth.client_id"),
+		ClientSecret: viper.GetString("auth.client_secret"),
+		Username:     viper.GetString("auth.username"),
+		Password:     viper.GetString("auth.password"),
+		RefreshToken: viper.GetString("auth.refresh_token"),
+		RedirectURI:  viper.GetString("auth.redirect_uri"),
+	}
+	if config.RedirectURI == "" {
+		config.RedirectURI = defaultRedirectURI
+	}
+
+	return config
+}
+
+// flow names the grant used to obtain tokens for config.
+func (c authConfig) flow() string {
+	if c.Username != "" && c.Password != "" {
+		return "script"
+	}
+
+	return "installed"
+}
+
+// tokenPath returns the location of the stored OAuth2 token inside the user's
+// config directory.
+func tokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "snoo-dl", "token.json"), nil
+}
+
+// configureAuth switches listing requests to oauth.reddit.com when a client ID
+// is configured, and leaves them anonymous otherwise.
+func configureAuth() error {
+	config := loadAuthConfig()
+	if config.ClientID == "" {
+		return nil
+	}
+
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+	auth, err := newRedditAuthenticator(config, path)
+	if err != nil {
+		return err
+	}
+
+	redditAuth = auth
+	redditURL = redditOAuthURL + "/r"
+	redditUserURL = redditOAuthURL + "/user"
+	return nil
+}
+
+// newRedditAuthenticator loads any token stored at tokenPath by an earlier
+// run or "auth login".
+func newRedditAuthenticator(config authConfig, tokenPath string) (*redditAuthenticator, error) {
+	auth := &redditAuthenticator{config: config, tokenPath: tokenPath}
+
+	data, err := os.ReadFile(tokenPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &auth.token); err != nil {
+			return nil, fmt.Errorf("invalid token file %s - %w", tokenPath, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// authorize sets the bearer token on a request to the Reddit API. A nil
+// authenticator leaves the request anonymous.
+func (a *redditAuthenticator) authorize(req *http.Request) error {
+	if a == nil {
+		return nil
+	}
+
+	token, err := a.accessToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	return nil
+}
+
+// accessToken returns a token valid for at least another minute.
+func (a *redditAuthenticator) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token.AccessToken != "" && time.Until(a.token.Expiry) > time.Minute {
+		return a.token.AccessToken, nil
+	}
+
+	form := url.Values{}
+	switch refreshToken := a.refreshToken(); {
+	case refreshToken != "":
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refreshToken)
+	case a.config.flow() == "script":
+		form.Set("grant_type", "password")
+		form.Set("username", a.config.Username)
+		form.Set("password", a.config.Password)
+	default:
+		return "", errors.New(`no reddit refresh token, run "snoo-dl auth login" or set auth.username and auth.password`)
+	}
+
+	token, err := requestToken(ctx, a.config, form)
+	if err != nil {
+		return "", err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = a.token.RefreshToken
+	}
+	a.token = token
+
+	if err := saveToken(a.tokenPath, token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// invalidate forces the next request to fetch a new access token, e.g. after
+// Reddit rejected the current one.
+func (a *redditAuthenticator) invalidate() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token.AccessToken = ""
+}
+
+// refreshToken prefers the configured refresh token over a stored one.
+func (a *redditAuthenticator) refreshToken() string {
+	if a.config.RefreshToken != "" {
+		return a.config.RefreshToken
+	}
+
+	return a.token.RefreshToken
+}
+
+// requestToken posts form to the token endpoint using the app's client
+// credentials.
+func requestToken(ctx context.Context, config authConfig, form url.Values) (oauthToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, redditTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthToken{}, err
+	}
+	req.SetBasicAuth(config.ClientID, config.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("reddit token request failed - %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		Scope        string `json:"scope"`
+		Error        string `json:"error"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return oauthToken{}, fmt.Errorf("reddit token request failed with status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return oauthToken{}, err
+	}
+	if body.Error != "" {
+		return oauthToken{}, fmt.Errorf("reddit token request failed: %s", body.Error)
+	}
+
+	return oauthToken{
+		AccessToken:  body.AccessToken,
+		RefreshToken: body.RefreshToken,
+		Scope:        body.Scope,
+		Expiry:       time.Now().Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func saveToken(path string, token oauthToken) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
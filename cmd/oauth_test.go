@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+// newTokenServer serves the token endpoint and a listing that requires the
+// most recently issued access token.
+func newTokenServer(t *testing.T, grants *[]string) *httptest.Server {
+	t.Helper()
+
+	issued := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			t.Errorf("unexpected client credentials %q:%q", id, secret)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse token form: %v", err)
+		}
+		*grants = append(*grants, r.PostForm.Get("grant_type"))
+		issued++
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "token-" + string(rune('0'+issued)),
+			"refresh_token": "refresh",
+			"expires_in":    3600,
+		})
+	})
+	mux.HandleFunc("/r/test/top.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer token-"+string(rune('0'+issued)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(models.Response{})
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func withTestAuth(t *testing.T, server *httptest.Server, config authConfig, stored oauthToken) *redditAuthenticator {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "token.json")
+	if stored.AccessToken != "" || stored.RefreshToken != "" {
+		if err := saveToken(path, stored); err != nil {
+			t.Fatalf("failed to store token: %v", err)
+		}
+	}
+	auth, err := newRedditAuthenticator(config, path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	originalAuth := redditAuth
+	originalTokenURL := redditTokenURL
+	originalClient := httpClient
+	redditAuth = auth
+	redditTokenURL = server.URL + "/api/v1/access_token"
+	httpClient = server.Client()
+	t.Cleanup(func() {
+		redditAuth = originalAuth
+		redditTokenURL = originalTokenURL
+		httpClient = originalClient
+	})
+
+	return auth
+}
+
+func TestFetchListingPageUsesPasswordGrant(t *testing.T) {
+	var grants []string
+	server := newTokenServer(t, &grants)
+	defer server.Close()
+
+	withTestAuth(t, server, authConfig{ClientID: "client", ClientSecret: "secret", Username: "me", Password: "pw"}, oauthToken{})
+
+	if _, err := fetchListingPage(context.Background(), server.URL+"/r/test/top.json"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := fetchListingPage(context.Background(), server.URL+"/r/test/top.json"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(grants) != 1 || grants[0] != "password" {
+		t.Fatalf("expected a single password grant reused across requests, got %v", grants)
+	}
+}
+
+func TestFetchListingPageRefreshesExpiredToken(t *testing.T) {
+	var grants []string
+	server := newTokenServer(t, &grants)
+	defer server.Close()
+
+	auth := withTestAuth(t, server, authConfig{ClientID: "client", ClientSecret: "secret"}, oauthToken{
+		AccessToken:  "expired",
+		RefreshToken: "stored-refresh",
+		Expiry:       time.Now().Add(-time.Hour),
+	})
+
+	if _, err := fetchListingPage(context.Background(), server.URL+"/r/test/top.json"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(grants) != 1 || grants[0] != "refresh_token" {
+		t.Fatalf("expected a refresh_token grant, got %v", grants)
+	}
+
+	reloaded, err := newRedditAuthenticator(auth.config, auth.tokenPath)
+	if err != nil {
+		t.Fatalf("expected no error reloading, got %v", err)
+	}
+	if reloaded.token.AccessToken != "token-1" {
+		t.Fatalf("expected refreshed token to be stored, got %q", reloaded.token.AccessToken)
+	}
+}
+
+func TestFetchListingPageRetriesRejectedToken(t *testing.T) {
+	var grants []string
+	server := newTokenServer(t, &grants)
+	defer server.Close()
+
+	withTestAuth(t, server, authConfig{ClientID: "client", ClientSecret: "secret"}, oauthToken{
+		AccessToken:  "revoked",
+		RefreshToken: "stored-refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	})
+
+	if _, err := fetchListingPage(context.Background(), server.URL+"/r/test/top.json"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(grants) != 1 {
+		t.Fatalf("expected the rejected token to be refreshed once, got %v", grants)
+	}
+}
+
+func TestAccessTokenRequiresRefreshTokenForInstalledApps(t *testing.T) {
+	auth, err := newRedditAuthenticator(authConfig{ClientID: "client"}, filepath.Join(t.TempDir(), "token.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := auth.accessToken(context.Background()); err == nil {
+		t.Fatal("expected an error without a refresh token")
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// partFileSuffix marks a download in progress. It is renamed to the final
+// path only once the transfer completes, so an interrupted download is never
+// mistaken for a finished file.
+const partFileSuffix = ".part"
+
+// activeParts holds the .part files being written by this process so two
+// workers never append to the same one.
+var activeParts sync.Map
+
+// claimPartFile reserves partPath for the calling worker. The returned
+// function releases it; ok is false when another worker already holds it.
+func claimPartFile(partPath string) (func(), bool) {
+	if _, loaded := activeParts.LoadOrStore(partPath, struct{}{}); loaded {
+		return nil, false
+	}
+
+	return func() { activeParts.Delete(partPath) }, true
+}
+
+// openPartFile opens or creates partPath for appending and returns the number
+// of bytes already downloaded into it.
+func openPartFile(partPath string) (*os.File, int64, error) {
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, offset, nil
+}
+
+// restartPartFile discards everything written to file so far.
+func restartPartFile(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return err
+}
+
+// hashPartPrefix feeds the bytes already in file to w and leaves the file
+// positioned at its end, ready for the resumed transfer.
+func hashPartPrefix(file *os.File, w io.Writer) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, file); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// contentRangeStart returns the first byte position of a
+// "bytes start-end/total" Content-Range header.
+func contentRangeStart(value string) (int64, bool) {
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// acceptsRanges reports whether the server advertised byte range support,
+// which is required to resume a partial file later.
+func acceptsRanges(response *http.Response) bool {
+	return response.StatusCode == http.StatusPartialContent ||
+		strings.EqualFold(strings.TrimSpace(response.Header.Get("Accept-Ranges")), "bytes")
+}
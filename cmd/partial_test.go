@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadFromURLResumesPartFile(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	var gotRange string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		http.ServeContent(w, r, "image.jpg", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	originalClient := httpClient
+	httpClient = server.Client()
+	defer func() { httpClient = originalClient }()
+
+	location := t.TempDir()
+	partPath := filepath.Join(location, "resumed.jpg"+partFileSuffix)
+	if err := os.WriteFile(partPath, content[:400], 0o644); err != nil {
+		t.Fatalf("failed to write part file: %v", err)
+	}
+
+	path, err := downloadFromURL(context.Background(), io.Discard, nil, server.URL+"/image.jpg", "resumed", location, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if gotRange != "bytes=400-" {
+		t.Fatalf("expected a Range request from byte 400, got %q", gotRange)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected %s to exist: %v", path, err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("expected resumed file to match the original, got %d bytes", len(got))
+	}
+	if _, err := os.Stat(partPath); err == nil {
+		t.Fatal("expected part file to be renamed")
+	}
+}
+
+func TestDownloadFromURLRestartsWhenRangeIgnored(t *testing.T) {
+	content := []byte("complete-image-bytes")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	originalClient := httpClient
+	httpClient = server.Client()
+	defer func() { httpClient = originalClient }()
+
+	location := t.TempDir()
+	partPath := filepath.Join(location, "restarted.jpg"+partFileSuffix)
+	if err := os.WriteFile(partPath, []byte("stale"), 0o644); err != nil {
+		t.Fatalf("failed to write part file: %v", err)
+	}
+
+	path, err := downloadFromURL(context.Background(), io.Discard, nil, server.URL+"/image.jpg", "restarted", location, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected %s to exist: %v", path, err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("expected %q, got %q", content, got)
+	}
+}
+
+func TestDownloadFromURLRemovesPartFileOnFailedRequest(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	originalClient := httpClient
+	httpClient = server.Client()
+	defer func() { httpClient = originalClient }()
+
+	location := t.TempDir()
+	if _, err := downloadFromURL(context.Background(), io.Discard, nil, server.URL+"/missing.jpg", "missing", location, nil); err == nil {
+		t.Fatal("expected an error for a missing image")
+	}
+
+	entries, err := os.ReadDir(location)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", location, err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no files to be left behind, got %v", entries)
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	if start, ok := contentRangeStart("bytes 400-999/1000"); !ok || start != 400 {
+		t.Fatalf("expected 400, got %d (%v)", start, ok)
+	}
+	if _, ok := contentRangeStart("bytes */1000"); ok {
+		t.Fatal("expected unsatisfied range to be rejected")
+	}
+}
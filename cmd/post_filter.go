@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/cobra"
+)
+
+const (
+	nsfwInclude = "include"
+	nsfwExclude = "exclude"
+	nsfwOnly    = "only"
+)
+
+// addPostFilterFlags registers the flags selecting which posts to consider
+// before any of their images are looked at.
+func addPostFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("min-score", 0, "only download posts with at least this score (0 for no minimum)")
+	cmd.Flags().String("nsfw", nsfwInclude, "how to treat posts marked NSFW (include|exclude|only)")
+	cmd.Flags().StringArray("flair", nil, "only download posts with this link flair, may be repeated")
+	cmd.Flags().String("title-match", "", "only download posts whose title matches this regular expression")
+	cmd.Flags().String("title-exclude", "", "skip posts whose title matches this regular expression")
+	cmd.Flags().StringArray("author", nil, "only download posts by this user, may be repeated")
+	cmd.Flags().StringArray("exclude-author", nil, "skip posts by this user, may be repeated")
+	cmd.Flags().String("since", "", "only download posts submitted after this date or age (i.e. 2024-03-01, 7d)")
+	cmd.Flags().String("until", "", "only download posts submitted before this date or age (i.e. 2024-03-31, 24h)")
+}
+
+// parsePostFilter reads and validates the settings registered by
+// addPostFilterFlags.
+func parsePostFilter(s settings) (models.PostFilter, error) {
+	filter := models.PostFilter{
+		MinScore:       s.getInt("min-score"),
+		NSFW:           strings.ToLower(s.getString("nsfw")),
+		Flairs:         s.getStringSlice("flair"),
+		Authors:        trimUserPrefixes(s.getStringSlice("author")),
+		ExcludeAuthors: trimUserPrefixes(s.getStringSlice("exclude-author")),
+	}
+
+	switch filter.NSFW {
+	case "":
+		filter.NSFW = nsfwInclude
+	case nsfwInclude, nsfwExclude, nsfwOnly:
+	default:
+		return models.PostFilter{}, errors.New("provided nsfw policy was invalid. Valid policies are: include|exclude|only")
+	}
+
+	var err error
+	if filter.TitleMatch, err = compileTitlePattern(s.getString("title-match"), "title-match"); err != nil {
+		return models.PostFilter{}, err
+	}
+	if filter.TitleExclude, err = compileTitlePattern(s.getString("title-exclude"), "title-exclude"); err != nil {
+		return models.PostFilter{}, err
+	}
+
+	now := time.Now()
+	if filter.Since, err = parsePostTime(s.getString("since"), now, false); err != nil {
+		return models.PostFilter{}, err
+	}
+	if filter.Until, err = parsePostTime(s.getString("until"), now, true); err != nil {
+		return models.PostFilter{}, err
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return models.PostFilter{}, errors.New("since must be before until")
+	}
+
+	return filter, nil
+}
+
+// compileTitlePattern compiles a case-insensitive title regular expression.
+// An empty pattern returns nil.
+func compileTitlePattern(pattern string, flagName string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s expression - %w", flagName, err)
+	}
+
+	return re, nil
+}
+
+// parsePostTime parses a --since or --until value: an RFC 3339 time, a local
+// date, or an age such as "7d" counted back from now. A date used as an upper
+// bound includes the whole day. An empty value returns the zero time.
+func parsePostTime(value string, now time.Time, upperBound bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		if upperBound {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if age, err := parseAge(value); err == nil {
+		return now.Add(-age), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD, RFC 3339 or an age such as 7d", value)
+}
+
+func trimUserPrefixes(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(name), "/"), "u/")
+		if name != "" {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+// matchesPostFilter reports whether post meets every criterion of filter.
+func matchesPostFilter(post models.PostData, filter models.PostFilter) bool {
+	if filter.MinScore != 0 && post.Score < filter.MinScore {
+		return false
+	}
+
+	switch filter.NSFW {
+	case nsfwExclude:
+		if post.Over18 {
+			return false
+		}
+	case nsfwOnly:
+		if !post.Over18 {
+			return false
+		}
+	}
+
+	if len(filter.Flairs) > 0 && !containsFold(filter.Flairs, strings.TrimSpace(post.LinkFlairText)) {
+		return false
+	}
+	if filter.TitleMatch != nil && !filter.TitleMatch.MatchString(post.Title) {
+		return false
+	}
+	if filter.TitleExclude != nil && filter.TitleExclude.MatchString(post.Title) {
+		return false
+	}
+	if len(filter.Authors) > 0 && !containsFold(filter.Authors, post.Author) {
+		return false
+	}
+	if containsFold(filter.ExcludeAuthors, post.Author) {
+		return false
+	}
+
+	created := post.Created()
+	if !filter.Since.IsZero() && created.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !created.Before(filter.Until) {
+		return false
+	}
+
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(candidate string) bool {
+		return strings.EqualFold(strings.TrimSpace(candidate), value)
+	})
+}
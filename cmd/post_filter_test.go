@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestMatchesPostFilter(t *testing.T) {
+	created := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+	post := models.PostData{
+		Title:         "Misty lake at dawn [OC]",
+		Author:        "Alice",
+		Score:         150,
+		Over18:        false,
+		LinkFlairText: "OC",
+		CreatedUTC:    float64(created.Unix()),
+	}
+
+	tests := []struct {
+		name   string
+		filter models.PostFilter
+		want   bool
+	}{
+		{"empty filter", models.PostFilter{}, true},
+		{"score met", models.PostFilter{MinScore: 150}, true},
+		{"score too low", models.PostFilter{MinScore: 151}, false},
+		{"nsfw excluded", models.PostFilter{NSFW: nsfwExclude}, true},
+		{"nsfw only", models.PostFilter{NSFW: nsfwOnly}, false},
+		{"flair matches", models.PostFilter{Flairs: []string{"oc", "Art"}}, true},
+		{"flair missing", models.PostFilter{Flairs: []string{"Art"}}, false},
+		{"title matches", models.PostFilter{TitleMatch: regexp.MustCompile(`(?i)lake`)}, true},
+		{"title excluded", models.PostFilter{TitleExclude: regexp.MustCompile(`(?i)\[oc\]`)}, false},
+		{"author matches", models.PostFilter{Authors: []string{"alice"}}, true},
+		{"author excluded", models.PostFilter{ExcludeAuthors: []string{"ALICE"}}, false},
+		{"inside window", models.PostFilter{Since: created.Add(-time.Hour), Until: created.Add(time.Hour)}, true},
+		{"before since", models.PostFilter{Since: created.Add(time.Second)}, false},
+		{"at until", models.PostFilter{Until: created}, false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesPostFilter(post, tt.filter); got != tt.want {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParsePostTime(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 8, 0, 0, 0, time.UTC)
+
+	got, err := parsePostTime("7d", now, false)
+	if err != nil || !got.Equal(now.AddDate(0, 0, -7)) {
+		t.Fatalf("expected 7 days before now, got %v (%v)", got, err)
+	}
+
+	got, err = parsePostTime("2024-03-05", now, true)
+	want := time.Date(2024, time.March, 6, 0, 0, 0, 0, time.Local)
+	if err != nil || !got.Equal(want) {
+		t.Fatalf("expected an until date to include the whole day (%v), got %v (%v)", want, got, err)
+	}
+
+	got, err = parsePostTime("2024-03-05T10:00:00Z", now, false)
+	if err != nil || !got.Equal(time.Date(2024, time.March, 5, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected RFC 3339 time %v (%v)", got, err)
+	}
+
+	if _, err := parsePostTime("last tuesday", now, false); err == nil {
+		t.Fatal("expected an error for an invalid date")
+	}
+}
+
+func TestParsePostFilterValidatesSettings(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	cmd := &cobra.Command{}
+	addPostFilterFlags(cmd)
+	if err := bindFlags(cmd); err != nil {
+		t.Fatalf("expected no error binding flags, got %v", err)
+	}
+	s := settings{flags: cmd.Flags()}
+
+	for _, author := range []string{"u/bob", "carol"} {
+		if err := cmd.Flags().Set("author", author); err != nil {
+			t.Fatalf("failed to set author flag: %v", err)
+		}
+	}
+	filter, err := parsePostFilter(s)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if filter.NSFW != nsfwInclude || len(filter.Authors) != 2 || filter.Authors[0] != "bob" {
+		t.Fatalf("unexpected post filter: %+v", filter)
+	}
+
+	for flag, value := range map[string]string{
+		"nsfw":          "sometimes",
+		"title-match":   "(",
+		"since":         "tomorrow",
+		"title-exclude": "[",
+	} {
+		t.Run(flag, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			addPostFilterFlags(cmd)
+			if err := cmd.Flags().Set(flag, value); err != nil {
+				t.Fatalf("failed to set %s flag: %v", flag, err)
+			}
+			if err := bindFlags(cmd); err != nil {
+				t.Fatalf("expected no error binding flags, got %v", err)
+			}
+			if _, err := parsePostFilter(settings{flags: cmd.Flags()}); err == nil {
+				t.Fatalf("expected an error for --%s %q", flag, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// settings resolves the value of a download setting. Flags given on the
+// command line take precedence over the selected profile, which takes
+// precedence over SNOODL_* environment variables and the rest of the config
+// file.
+type settings struct {
+	flags   *pflag.FlagSet
+	profile map[string]any
+
+	// keys maps the flags bound to a command-specific config key (see
+	// commandConfigKeys) to that key. Profiles do not set them.
+	keys map[string]string
+}
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run {PROFILE...}",
+	Short: "Download with one or more profiles from the config file",
+	Long: `run - executes the named profiles from the profiles section of the
+	config file one after another, i.e.
+
+	profiles:
+	  phone:
+	    subreddits: [iphonewallpapers, mobilewallpaper]
+	    sort: top
+	    period: month
+	    aspect-ratio: 9:19.5
+	    location: ./phone
+
+	A profile accepts every download flag as a key, plus period. Flags given
+	on the command line override the profile.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		if all && len(args) > 0 {
+			return errors.New("PROFILE arguments cannot be combined with --all")
+		}
+		if !all && len(args) == 0 {
+			return errors.New("a PROFILE argument or --all is required")
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := bindFlags(cmd); err != nil {
+			return err
+		}
+
+		names := args
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			names = profileNames()
+			if len(names) == 0 {
+				return errors.New("no profiles are defined in the config file")
+			}
+		}
+
+		// Resolve every profile up front so a typo does not surface only
+		// after the earlier profiles have finished downloading.
+		profiles := make([]settings, 0, len(names))
+		for _, name := range names {
+			profile, err := loadProfile(name)
+			if err != nil {
+				return err
+			}
+			profiles = append(profiles, settings{flags: cmd.Flags(), profile: profile})
+		}
+
+		var err error
+		for i, s := range profiles {
+			fmt.Fprintln(cmd.OutOrStdout(), "==> profile", names[i])
+			if runErr := runDownload(cmd.Context(), s, nil); runErr != nil {
+				if cmd.Context().Err() != nil {
+					return runErr
+				}
+				err = errors.Join(err, fmt.Errorf("profile %s - %w", names[i], runErr))
+			}
+		}
+
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().Bool("all", false, "run every profile in the config file")
+	addListingFlags(runCmd)
+	addDownloadFlags(runCmd)
+}
+
+// commandSettings binds the flags of cmd and loads the profile selected with
+// --profile, if cmd has that flag.
+func commandSettings(cmd *cobra.Command) (settings, error) {
+	if err := bindFlags(cmd); err != nil {
+		return settings{}, err
+	}
+
+	s := settings{flags: cmd.Flags(), keys: commandConfigKeys[cmd.Name()]}
+	if cmd.Flags().Lookup("profile") == nil {
+		return s, nil
+	}
+	if name := viper.GetString("profile"); name != "" {
+		profile, err := loadProfile(name)
+		if err != nil {
+			return settings{}, err
+		}
+		s.profile = profile
+	}
+
+	return s, nil
+}
+
+// profileNames returns the names of every profile in the config file, sorted.
+func profileNames() []string {
+	names := make([]string, 0)
+	for name := range viper.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// loadProfile returns the settings of the named profile keyed by flag name.
+// Keys may also be spelled with underscores, and subreddits is accepted for
+// subreddit.
+func loadProfile(name string) (map[string]any, error) {
+	raw, ok := viper.GetStringMap("profiles")[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("profile %q is not defined in the config file", name)
+	}
+	values, err := cast.ToStringMapE(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %s - %w", name, err)
+	}
+
+	valid := profileKeys()
+	profile := make(map[string]any, len(values))
+	for key, value := range values {
+		key = strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if key == "subreddits" {
+			key = "subreddit"
+		}
+		if _, ok := valid[key]; !ok {
+			return nil, fmt.Errorf("invalid profile %s - unknown setting %q", name, key)
+		}
+		profile[key] = value
+	}
+
+	return profile, nil
+}
+
+// profileKeys are the settings a profile may define: every listing and
+// download flag, plus period.
+func profileKeys() map[string]struct{} {
+	flags := &cobra.Command{}
+	addListingFlags(flags)
+	addDownloadFlags(flags)
+
+	keys := map[string]struct{}{"period": {}}
+	flags.Flags().VisitAll(func(flag *pflag.Flag) {
+		keys[flag.Name] = struct{}{}
+	})
+
+	return keys
+}
+
+// get returns the value of key from a flag given on the command line, the
+// profile or viper, in that order.
+func (s settings) get(key string) any {
+	if scoped, ok := s.keys[key]; ok {
+		return viper.Get(scoped)
+	}
+	if value, ok := s.profile[key]; ok {
+		if flag := s.flags.Lookup(key); flag == nil || !flag.Changed {
+			return value
+		}
+	}
+
+	return viper.Get(key)
+}
+
+func (s settings) getString(key string) string {
+	return cast.ToString(s.get(key))
+}
+
+func (s settings) getInt(key string) int {
+	return cast.ToInt(s.get(key))
+}
+
+func (s settings) getFloat64(key string) float64 {
+	return cast.ToFloat64(s.get(key))
+}
+
+func (s settings) getBool(key string) bool {
+	return cast.ToBool(s.get(key))
+}
+
+func (s settings) getDuration(key string) time.Duration {
+	return cast.ToDuration(s.get(key))
+}
+
+func (s settings) getStringSlice(key string) []string {
+	return cast.ToStringSlice(s.get(key))
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func readTestConfig(t *testing.T, config string) {
+	t.Helper()
+	t.Cleanup(viper.Reset)
+
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+}
+
+func TestLoadProfileNormalizesKeys(t *testing.T) {
+	readTestConfig(t, `
+profiles:
+  phone:
+    subreddits: [iphonewallpapers, mobilewallpaper]
+    aspect_ratio: "9:16"
+    location: ./phone
+`)
+
+	profile, err := loadProfile("phone")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := profile["subreddit"]; !ok {
+		t.Fatalf("expected subreddits to be stored as subreddit, got %v", profile)
+	}
+	if profile["aspect-ratio"] != "9:16" {
+		t.Fatalf("expected aspect_ratio to be stored as aspect-ratio, got %v", profile)
+	}
+
+	if _, err := loadProfile("desktop"); err == nil {
+		t.Fatal("expected an error for an undefined profile")
+	}
+}
+
+func TestLoadProfileRejectsUnknownSettings(t *testing.T) {
+	readTestConfig(t, "profiles:\n  phone:\n    resolutoin: 1080x1920\n")
+
+	if _, err := loadProfile("phone"); err == nil {
+		t.Fatal("expected an error for a misspelled setting")
+	}
+}
+
+func TestProfileSettingsPrecedence(t *testing.T) {
+	readTestConfig(t, `
+location: ./wallpapers
+limit: 50
+profiles:
+  desktop:
+    subreddit: earthporn+spaceporn
+    sort: new
+    location: ./desktop
+    limit: 10
+`)
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("profile", "", "")
+	addListingFlags(cmd)
+	addDownloadFlags(cmd)
+	for name, value := range map[string]string{"profile": "desktop", "limit": "5"} {
+		if err := cmd.Flags().Set(name, value); err != nil {
+			t.Fatalf("failed to set %s flag: %v", name, err)
+		}
+	}
+
+	s, err := commandSettings(cmd)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := s.getString("location"); got != "./desktop" {
+		t.Fatalf("expected the profile to override the config file, got %q", got)
+	}
+	if got := s.getInt("limit"); got != 5 {
+		t.Fatalf("expected the flag to override the profile, got %d", got)
+	}
+	if got := s.getInt("concurrency"); got != defaultConcurrency {
+		t.Fatalf("expected the default concurrency, got %d", got)
+	}
+
+	listing, err := downloadListing(s, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Join(listing.Subreddits, ",") != "earthporn,spaceporn" || listing.Sort != "new" {
+		t.Fatalf("unexpected listing from profile: %+v", listing)
+	}
+}
+
+func TestRunArgsRequiresProfileOrAll(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("all", false, "")
+
+	if err := runCmd.Args(cmd, nil); err == nil {
+		t.Fatal("expected an error without a profile")
+	}
+	if err := runCmd.Args(cmd, []string{"phone"}); err != nil {
+		t.Fatalf("expected a profile argument to be accepted, got %v", err)
+	}
+
+	if err := cmd.Flags().Set("all", "true"); err != nil {
+		t.Fatalf("failed to set all flag: %v", err)
+	}
+	if err := runCmd.Args(cmd, []string{"phone"}); err == nil {
+		t.Fatal("expected an error when combining a profile with --all")
+	}
+}
@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+// ffmpegPath is the ffmpeg executable used to mux the video and audio
+// tracks of Reddit videos, looked up in PATH.
+var ffmpegPath = "ffmpeg"
+
+// The video and audio streams of a Reddit video are downloaded next to it
+// under its name plus one of these suffixes until they are muxed.
+const (
+	videoStreamSuffix = ".video"
+	audioStreamSuffix = ".audio"
+)
+
+func init() {
+	registerResolver(redditVideoResolver{})
+}
+
+// redditVideoResolver resolves v.redd.it links using the reddit_video media
+// of the post. Posts without it, such as crossposts, have no candidates.
+type redditVideoResolver struct{}
+
+func (redditVideoResolver) Match(link *url.URL) bool {
+	return strings.EqualFold(link.Hostname(), "v.redd.it")
+}
+
+func (redditVideoResolver) Resolve(_ context.Context, _ *url.URL, post *models.PostData) ([]imageCandidate, error) {
+	video := post.RedditVideo()
+	if video == nil || video.FallbackURL == "" {
+		return nil, nil
+	}
+
+	return []imageCandidate{{
+		URL:     html.UnescapeString(video.FallbackURL),
+		Width:   video.Width,
+		Height:  video.Height,
+		DashURL: html.UnescapeString(video.DashURL),
+	}}, nil
+}
+
+// dashStream is a representation listed in a DASH manifest.
+type dashStream struct {
+	URL       string
+	Height    int
+	Bandwidth int
+}
+
+// dashStreams are the video and audio representations of a DASH manifest.
+type dashStreams struct {
+	Video []dashStream
+	Audio []dashStream
+}
+
+type dashManifest struct {
+	Periods []struct {
+		AdaptationSets []struct {
+			ContentType     string `xml:"contentType,attr"`
+			MimeType        string `xml:"mimeType,attr"`
+			Representations []struct {
+				MimeType  string `xml:"mimeType,attr"`
+				Bandwidth int    `xml:"bandwidth,attr"`
+				Height    int    `xml:"height,attr"`
+				BaseURL   string `xml:"BaseURL"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+// parseDASHManifest lists the streams of the manifest at manifestURL, whose
+// BaseURLs are relative to it. Older Reddit manifests only set mimeType on
+// each representation, and some neither, in which case audio is told apart
+// by its file name (DASH_audio.mp4, DASH_AUDIO_128.mp4).
+func parseDASHManifest(r io.Reader, manifestURL string) (dashStreams, error) {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return dashStreams{}, err
+	}
+
+	var manifest dashManifest
+	if err := xml.NewDecoder(r).Decode(&manifest); err != nil {
+		return dashStreams{}, fmt.Errorf("invalid DASH manifest - %w", err)
+	}
+
+	var streams dashStreams
+	for _, period := range manifest.Periods {
+		for _, set := range period.AdaptationSets {
+			for _, representation := range set.Representations {
+				ref, err := url.Parse(strings.TrimSpace(representation.BaseURL))
+				if err != nil || ref.String() == "" {
+					continue
+				}
+				stream := dashStream{
+					URL:       base.ResolveReference(ref).String(),
+					Height:    representation.Height,
+					Bandwidth: representation.Bandwidth,
+				}
+
+				kind := set.ContentType + " " + set.MimeType + " " + representation.MimeType
+				switch {
+				case strings.Contains(kind, "audio"):
+					streams.Audio = append(streams.Audio, stream)
+				case strings.Contains(kind, "video"):
+					streams.Video = append(streams.Video, stream)
+				case strings.Contains(strings.ToLower(ref.Path), "audio"):
+					streams.Audio = append(streams.Audio, stream)
+				default:
+					streams.Video = append(streams.Video, stream)
+				}
+			}
+		}
+	}
+
+	return streams, nil
+}
+
+// chooseVideoStream picks the tallest video no taller than maxHeight, or the
+// tallest of all when maxHeight is 0. When every video is too tall the
+// smallest is picked. Ties go to the higher bandwidth.
+func chooseVideoStream(streams []dashStream, maxHeight int) (dashStream, bool) {
+	var best, smallest dashStream
+	found := false
+	for i, stream := range streams {
+		if i == 0 || stream.Height < smallest.Height || (stream.Height == smallest.Height && stream.Bandwidth > smallest.Bandwidth) {
+			smallest = stream
+		}
+		if maxHeight > 0 && stream.Height > maxHeight {
+			continue
+		}
+		if !found || stream.Height > best.Height || (stream.Height == best.Height && stream.Bandwidth > best.Bandwidth) {
+			best = stream
+			found = true
+		}
+	}
+	if !found {
+		return smallest, len(streams) > 0
+	}
+
+	return best, true
+}
+
+// chooseAudioStream picks the audio stream of the highest bandwidth.
+func chooseAudioStream(streams []dashStream) (dashStream, bool) {
+	var best dashStream
+	for i, stream := range streams {
+		if i == 0 || stream.Bandwidth > best.Bandwidth {
+			best = stream
+		}
+	}
+
+	return best, len(streams) > 0
+}
+
+// fetchDASHManifest downloads and parses the manifest at manifestURL.
+func fetchDASHManifest(ctx context.Context, manifestURL string) (dashStreams, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return dashStreams{}, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return dashStreams{}, fmt.Errorf("error while requesting %s - %w", manifestURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return dashStreams{}, newStatusError("DASH manifest request", resp)
+	}
+
+	return parseDASHManifest(resp.Body, manifestURL)
+}
+
+// downloadRedditVideo downloads the video of job to <name>.mp4. The video
+// quality is chosen from the DASH manifest by job.MaxHeight, and the audio
+// track is muxed in with ffmpeg when it is installed. Without ffmpeg, an
+// audio track or a readable manifest, only the video is saved.
+func downloadRedditVideo(ctx context.Context, out io.Writer, job downloadJob) (string, error) {
+	location := job.Location
+	if location == "" {
+		location = defaultLocation
+	}
+	fileName := sanitizeFilePath(job.Name) + ".mp4"
+	path := filepath.Join(location, filepath.FromSlash(fileName))
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintln(out, "File already exists, skipping:", path)
+		return path, nil
+	}
+
+	videoURL, audioURL := job.URL, ""
+	if job.DashURL != "" {
+		streams, err := fetchDASHManifest(ctx, job.DashURL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			fmt.Fprintln(out, "Cannot read the DASH manifest, downloading the fallback video:", err)
+		} else {
+			if video, ok := chooseVideoStream(streams.Video, job.MaxHeight); ok {
+				videoURL = video.URL
+			}
+			if audio, ok := chooseAudioStream(streams.Audio); ok {
+				audioURL = audio.URL
+			}
+		}
+	}
+
+	ffmpeg := ""
+	if audioURL != "" {
+		var err error
+		if ffmpeg, err = exec.LookPath(ffmpegPath); err != nil {
+			fmt.Fprintln(out, "ffmpeg not found, saving the video without audio")
+			audioURL = ""
+		}
+	}
+	// Streams of older manifests (DASH_720, audio) have no extension.
+	download := func(streamURL string, name string) (string, error) {
+		if imageExtension(streamURL) == "" {
+			name += ".mp4"
+		}
+		return downloadFromURL(ctx, out, nil, streamURL, name, location, nil)
+	}
+
+	if audioURL == "" {
+		return download(videoURL, job.Name)
+	}
+
+	videoPath, err := download(videoURL, job.Name+videoStreamSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	audioPath, err := download(audioURL, job.Name+audioStreamSuffix)
+	if err == nil {
+		defer os.Remove(audioPath)
+		err = muxVideo(ctx, ffmpeg, videoPath, audioPath, path)
+	}
+	if err != nil {
+		// Keep the video stream for the next run rather than saving a
+		// video without audio because the run was cancelled.
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		fmt.Fprintln(out, "Cannot add the audio track, saving the video without audio:", err)
+		if err := os.Rename(videoPath, path); err != nil {
+			return "", fmt.Errorf("error while creating %s - %w", path, err)
+		}
+		return path, nil
+	}
+
+	os.Remove(videoPath)
+	fmt.Fprintln(out, "Muxed video and audio into", fileName)
+
+	return path, nil
+}
+
+// muxVideo copies the video of videoPath and the audio of audioPath into a
+// new MP4 at path without re-encoding either.
+func muxVideo(ctx context.Context, ffmpeg string, videoPath string, audioPath string, path string) error {
+	partPath := path + partFileSuffix
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-y", "-loglevel", "error",
+		"-i", videoPath, "-i", audioPath,
+		"-map", "0:v:0", "-map", "1:a:0", "-c", "copy",
+		"-movflags", "+faststart", "-f", "mp4", partPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(partPath)
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("ffmpeg failed - %w: %s", err, message)
+		}
+		return fmt.Errorf("ffmpeg failed - %w", err)
+	}
+
+	if err := os.Rename(partPath, path); err != nil {
+		os.Remove(partPath)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+const testDASHManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" mediaPresentationDuration="PT10S">
+  <Period>
+    <AdaptationSet contentType="video" mimeType="video/mp4">
+      <Representation bandwidth="1200000" height="480" width="854"><BaseURL>DASH_480.mp4</BaseURL></Representation>
+      <Representation bandwidth="4800000" height="1080" width="1920"><BaseURL>DASH_1080.mp4</BaseURL></Representation>
+      <Representation bandwidth="2400000" height="720" width="1280"><BaseURL>DASH_720.mp4</BaseURL></Representation>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" mimeType="audio/mp4">
+      <Representation bandwidth="64000"><BaseURL>DASH_AUDIO_64.mp4</BaseURL></Representation>
+      <Representation bandwidth="128000"><BaseURL>DASH_AUDIO_128.mp4</BaseURL></Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func TestParseDASHManifest(t *testing.T) {
+	streams, err := parseDASHManifest(strings.NewReader(testDASHManifest), "https://v.redd.it/abc/DASHPlaylist.mpd?a=1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(streams.Video) != 3 || len(streams.Audio) != 2 {
+		t.Fatalf("expected 3 video and 2 audio streams, got %+v", streams)
+	}
+
+	tests := []struct {
+		maxHeight int
+		want      string
+	}{
+		{0, "https://v.redd.it/abc/DASH_1080.mp4"},
+		{720, "https://v.redd.it/abc/DASH_720.mp4"},
+		{1000, "https://v.redd.it/abc/DASH_720.mp4"},
+		{240, "https://v.redd.it/abc/DASH_480.mp4"},
+	}
+	for _, tt := range tests {
+		video, ok := chooseVideoStream(streams.Video, tt.maxHeight)
+		if !ok || video.URL != tt.want {
+			t.Fatalf("max height %d: expected %s, got %+v", tt.maxHeight, tt.want, video)
+		}
+	}
+
+	audio, ok := chooseAudioStream(streams.Audio)
+	if !ok || audio.URL != "https://v.redd.it/abc/DASH_AUDIO_128.mp4" {
+		t.Fatalf("expected the 128k audio stream, got %+v", audio)
+	}
+}
+
+func TestParseDASHManifestWithoutContentTypes(t *testing.T) {
+	manifest := `<MPD><Period><AdaptationSet>
+		<Representation height="360"><BaseURL>DASH_360</BaseURL></Representation>
+		<Representation><BaseURL>audio</BaseURL></Representation>
+	</AdaptationSet></Period></MPD>`
+
+	streams, err := parseDASHManifest(strings.NewReader(manifest), "https://v.redd.it/abc/DASHPlaylist.mpd")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(streams.Video) != 1 || streams.Video[0].URL != "https://v.redd.it/abc/DASH_360" {
+		t.Fatalf("expected one video stream, got %+v", streams.Video)
+	}
+	if len(streams.Audio) != 1 || streams.Audio[0].URL != "https://v.redd.it/abc/audio" {
+		t.Fatalf("expected one audio stream, got %+v", streams.Audio)
+	}
+}
+
+func TestResolvePostCandidatesReadsRedditVideo(t *testing.T) {
+	listing := `{"data": {"children": [{"kind": "t3", "data": {
+		"id": "abc", "title": "Timelapse", "url": "https://v.redd.it/abc", "is_video": true,
+		"secure_media": {"reddit_video": {
+			"fallback_url": "https://v.redd.it/abc/DASH_1080.mp4?source=fallback",
+			"dash_url": "https://v.redd.it/abc/DASHPlaylist.mpd?a=1&amp;v=1&amp;f=sd",
+			"width": 1920, "height": 1080, "duration": 10, "is_gif": false
+		}}
+	}}]}}`
+
+	var response models.Response
+	if err := json.Unmarshal([]byte(listing), &response); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+
+	got, err := resolvePostCandidates(context.Background(), response.Data.Post[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].DashURL != "https://v.redd.it/abc/DASHPlaylist.mpd?a=1&v=1&f=sd" || got[0].Height != 1080 {
+		t.Fatalf("expected the video with its DASH manifest, got %+v", got)
+	}
+	if ext := imageExtension(got[0].URL); ext != ".mp4" {
+		t.Fatalf("expected the fallback to be an .mp4, got %q", ext)
+	}
+}
+
+// newRedditVideoTestServer serves testDASHManifest and a stream for each of
+// its representations, whose content is the stream's file name.
+func newRedditVideoTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(r.URL.Path)
+		if name == "DASHPlaylist.mpd" {
+			_, _ = io.WriteString(w, testDASHManifest)
+			return
+		}
+		_, _ = io.WriteString(w, name)
+	}))
+	t.Cleanup(server.Close)
+
+	originalClient := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = originalClient })
+
+	return server
+}
+
+func TestDownloadRedditVideoWithoutFFmpeg(t *testing.T) {
+	server := newRedditVideoTestServer(t)
+
+	originalFFmpeg := ffmpegPath
+	ffmpegPath = "snoo-dl-test-missing-ffmpeg"
+	defer func() { ffmpegPath = originalFFmpeg }()
+
+	location := t.TempDir()
+	var out bytes.Buffer
+	path, err := downloadRedditVideo(context.Background(), &out, downloadJob{
+		URL:       server.URL + "/abc/DASH_1080.mp4?source=fallback",
+		DashURL:   server.URL + "/abc/DASHPlaylist.mpd",
+		MaxHeight: 720,
+		Name:      "timelapse",
+		Location:  location,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected %s to exist: %v", path, err)
+	}
+	if filepath.Base(path) != "timelapse.mp4" || string(got) != "DASH_720.mp4" {
+		t.Fatalf("expected the 720p stream in timelapse.mp4, got %q in %s", got, path)
+	}
+	if !strings.Contains(out.String(), "ffmpeg not found") {
+		t.Fatalf("expected a note about the missing ffmpeg, got:\n%s", out.String())
+	}
+}
+
+func TestDownloadRedditVideoMuxesAudio(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the ffmpeg stand-in is a shell script")
+	}
+	server := newRedditVideoTestServer(t)
+
+	// The stand-in concatenates the -i inputs into the output file.
+	script := filepath.Join(t.TempDir(), "ffmpeg")
+	stub := `#!/bin/sh
+inputs=""
+while [ $# -gt 1 ]; do
+	if [ "$1" = "-i" ]; then inputs="$inputs $2"; shift; fi
+	shift
+done
+cat $inputs > "$1"
+`
+	if err := os.WriteFile(script, []byte(stub), 0o755); err != nil {
+		t.Fatalf("failed to write ffmpeg stand-in: %v", err)
+	}
+	originalFFmpeg := ffmpegPath
+	ffmpegPath = script
+	defer func() { ffmpegPath = originalFFmpeg }()
+
+	location := t.TempDir()
+	path, err := downloadRedditVideo(context.Background(), io.Discard, downloadJob{
+		URL:      server.URL + "/abc/DASH_1080.mp4?source=fallback",
+		DashURL:  server.URL + "/abc/DASHPlaylist.mpd",
+		Name:     "timelapse",
+		Location: location,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected %s to exist: %v", path, err)
+	}
+	if string(got) != "DASH_1080.mp4DASH_AUDIO_128.mp4" {
+		t.Fatalf("expected the 1080p video and 128k audio muxed, got %q", got)
+	}
+
+	entries, err := os.ReadDir(location)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", location, err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the muxed video to remain, got %v", entries)
+	}
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+// Resolver turns the links of a post on a media host into downloadable
+// images. New hosts implement it and register themselves with
+// registerResolver from an init function.
+type Resolver interface {
+	// Match reports whether link belongs to the host.
+	Match(link *url.URL) bool
+
+	// Resolve returns the images behind link, which Match accepted. post is
+	// the post link belongs to; the returned candidates need not set Post.
+	Resolve(ctx context.Context, link *url.URL, post *models.PostData) ([]imageCandidate, error)
+}
+
+// resolvers holds the registered host resolvers. The hosts they match must
+// not overlap, so their order does not matter. Links no resolver matches
+// are downloaded directly if they have an image extension.
+var resolvers []Resolver
+
+// registerResolver adds r to the host resolvers.
+func registerResolver(r Resolver) {
+	resolvers = append(resolvers, r)
+}
+
+func init() {
+	registerResolver(redditGalleryResolver{})
+}
+
+// resolvePostCandidates returns the images of post: those behind its link
+// and, for a gallery, those of each gallery item. A post without any, such
+// as a link to a GIF host, falls back to the animated variants of its
+// preview.
+func resolvePostCandidates(ctx context.Context, post models.Post) ([]imageCandidate, error) {
+	var candidates []imageCandidate
+	for _, link := range postLinks(post.Data) {
+		resolved, err := resolveLink(ctx, link, &post.Data)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, resolved...)
+	}
+	if len(candidates) == 0 {
+		if animation, ok := previewAnimation(&post.Data); ok {
+			candidates = append(candidates, animation)
+		}
+	}
+
+	for i := range candidates {
+		candidates[i].Post = &post.Data
+	}
+
+	return uniqueCandidates(candidates, nil), nil
+}
+
+// resolveLink resolves link with the first matching resolver, falling back
+// to the link itself when it is a direct image.
+func resolveLink(ctx context.Context, link *url.URL, post *models.PostData) ([]imageCandidate, error) {
+	for _, r := range resolvers {
+		if r.Match(link) {
+			return r.Resolve(ctx, link, post)
+		}
+	}
+
+	var direct directImageResolver
+	if direct.Match(link) {
+		return direct.Resolve(ctx, link, post)
+	}
+
+	return nil, nil
+}
+
+// postLinks returns the distinct URLs a post links to. Gallery posts also
+// link to their gallery, even when Reddit reports another URL.
+func postLinks(post models.PostData) []*url.URL {
+	rawURLs := []string{post.URLOverriddenByDest, post.Url}
+	if post.IsGallery {
+		rawURLs = append(rawURLs, "https://www.reddit.com/gallery/"+post.ID)
+	}
+
+	links := make([]*url.URL, 0, len(rawURLs))
+	seen := make(map[string]struct{}, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		unescaped := html.UnescapeString(strings.TrimSpace(rawURL))
+		if unescaped == "" {
+			continue
+		}
+		if _, ok := seen[unescaped]; ok {
+			continue
+		}
+		seen[unescaped] = struct{}{}
+
+		link, err := url.Parse(unescaped)
+		if err != nil {
+			continue
+		}
+		links = append(links, link)
+	}
+
+	return links
+}
+
+// previewSize returns the size of the post preview, or zeros without one.
+func previewSize(post *models.PostData) (int, int) {
+	if len(post.Preview.Images) == 0 {
+		return 0, 0
+	}
+
+	source := post.Preview.Images[0].Source
+	return source.Width, source.Height
+}
+
+// directImageResolver downloads links to a supported image file as they
+// are, such as i.redd.it and i.imgur.com links. The size is taken from the
+// post preview, which also provides the MP4 form of a GIF.
+type directImageResolver struct{}
+
+func (directImageResolver) Match(link *url.URL) bool {
+	return hasSupportedImageExtension(link.String())
+}
+
+func (directImageResolver) Resolve(_ context.Context, link *url.URL, post *models.PostData) ([]imageCandidate, error) {
+	width, height := previewSize(post)
+	candidate := imageCandidate{URL: link.String(), Width: width, Height: height}
+	if imageExtension(candidate.URL) == ".gif" {
+		candidate.GIFURL = candidate.URL
+		if animation, ok := previewAnimation(post); ok {
+			candidate.MP4URL = animation.MP4URL
+		}
+	}
+
+	return []imageCandidate{candidate}, nil
+}
+
+// redditGalleryResolver resolves reddit.com/gallery links to the items in
+// the gallery data of the post, in gallery order, using their media
+// metadata. Items Reddit has not processed (status other than valid) are
+// skipped; each candidate keeps its position, caption and outbound link.
+type redditGalleryResolver struct{}
+
+func (redditGalleryResolver) Match(link *url.URL) bool {
+	return isRedditHost(link.Hostname()) && strings.HasPrefix(link.Path, "/gallery/")
+}
+
+func (redditGalleryResolver) Resolve(_ context.Context, _ *url.URL, post *models.PostData) ([]imageCandidate, error) {
+	if !post.IsGallery {
+		return nil, nil
+	}
+
+	candidates := make([]imageCandidate, 0, len(post.GalleryData.Items))
+	for i, item := range post.GalleryData.Items {
+		meta, ok := post.MediaMetadata[item.MediaID]
+		if !ok || (meta.Status != "" && meta.Status != "valid") {
+			continue
+		}
+
+		candidate := imageCandidate{
+			Width:        meta.S.X,
+			Height:       meta.S.Y,
+			GalleryIndex: i + 1,
+			Caption:      html.UnescapeString(strings.TrimSpace(item.Caption)),
+			OutboundURL:  html.UnescapeString(strings.TrimSpace(item.OutboundURL)),
+		}
+		if meta.S.GIF != "" || meta.S.MP4 != "" {
+			// Animated items (e: AnimatedImage) have no U.
+			candidate.GIFURL = html.UnescapeString(strings.TrimSpace(meta.S.GIF))
+			candidate.MP4URL = html.UnescapeString(strings.TrimSpace(meta.S.MP4))
+			candidate.URL = candidate.MP4URL
+			if candidate.URL == "" {
+				candidate.URL = candidate.GIFURL
+			}
+		} else {
+			candidate.URL = html.UnescapeString(strings.TrimSpace(meta.S.U))
+			if candidate.URL == "" || !hasSupportedImageExtension(candidate.URL) {
+				continue
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// isRedditHost reports whether host is reddit.com or one of its subdomains.
+func isRedditHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "reddit.com" || strings.HasSuffix(host, ".reddit.com")
+}
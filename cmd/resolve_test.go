@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+// artResolver is a host resolver for tests, resolving art.example pages to
+// a single full size image.
+type artResolver struct{}
+
+func (artResolver) Match(link *url.URL) bool {
+	return link.Hostname() == "art.example"
+}
+
+func (artResolver) Resolve(_ context.Context, link *url.URL, _ *models.PostData) ([]imageCandidate, error) {
+	if link.Path == "/private" {
+		return nil, errors.New("artwork is private")
+	}
+	return []imageCandidate{{URL: "https://cdn.art.example" + link.Path + "/full.png", Width: 4000, Height: 3000}}, nil
+}
+
+func TestResolvePostCandidatesUsesRegisteredResolvers(t *testing.T) {
+	originalResolvers := resolvers
+	defer func() { resolvers = originalResolvers }()
+	resolvers = append([]Resolver{}, originalResolvers...)
+	registerResolver(artResolver{})
+
+	post := models.Post{Data: models.PostData{ID: "abc", Url: "https://art.example/mountains"}}
+	got, err := resolvePostCandidates(context.Background(), post)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].URL != "https://cdn.art.example/mountains/full.png" || got[0].Width != 4000 || got[0].Post == nil {
+		t.Fatalf("expected the registered resolver to resolve the post, got %+v", got)
+	}
+
+	post = models.Post{Data: models.PostData{ID: "def", Url: "https://art.example/private"}}
+	if _, err := resolvePostCandidates(context.Background(), post); err == nil {
+		t.Fatal("expected the resolver error to be returned")
+	}
+}
+
+func TestResolvePostCandidatesResolvesGalleryLink(t *testing.T) {
+	post := models.Post{Data: models.PostData{
+		ID:        "abc",
+		Url:       "https://www.reddit.com/gallery/abc",
+		IsGallery: true,
+		GalleryData: models.GalleryData{
+			Items: []models.GalleryItem{{MediaID: "one"}, {MediaID: "two"}, {MediaID: "missing"}},
+		},
+		MediaMetadata: map[string]models.MediaMeta{},
+	}}
+	for id, source := range map[string]string{"one": "https://i.redd.it/one.jpg?width=3840&amp;s=x", "two": "https://i.redd.it/two.png"} {
+		meta := models.MediaMeta{}
+		meta.S.U, meta.S.X, meta.S.Y = source, 3840, 2160
+		post.Data.MediaMetadata[id] = meta
+	}
+
+	got, err := resolvePostCandidates(context.Background(), post)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 2 || got[0].URL != "https://i.redd.it/one.jpg?width=3840&s=x" || got[1].URL != "https://i.redd.it/two.png" {
+		t.Fatalf("expected both gallery images in order, got %+v", got)
+	}
+
+	post.Data.Url = "https://www.reddit.com/r/wallpapers/comments/abc/text_post/"
+	post.Data.IsGallery = false
+	if got, _ := resolvePostCandidates(context.Background(), post); len(got) != 0 {
+		t.Fatalf("expected no candidates for a text post, got %+v", got)
+	}
+}
+
+func TestResolvePostCandidatesReadsGalleryItems(t *testing.T) {
+	listing := `{"data": {"children": [{"kind": "t3", "data": {
+		"id": "abc", "title": "Alps", "url": "https://www.reddit.com/gallery/abc", "is_gallery": true,
+		"gallery_data": {"items": [
+			{"media_id": "m1", "id": 101, "caption": "Dawn &amp; mist", "outbound_url": "https://example.com/prints/1"},
+			{"media_id": "m2", "id": 102},
+			{"media_id": "m3", "id": 103, "caption": "Timelapse"},
+			{"media_id": "m4", "id": 104, "caption": "Dusk"}
+		]},
+		"media_metadata": {
+			"m1": {"id": "m1", "status": "valid", "e": "Image", "m": "image/jpg", "s": {"u": "https://preview.redd.it/m1.jpg?width=3840&amp;s=a", "x": 3840, "y": 2160}},
+			"m2": {"id": "m2", "status": "unprocessed"},
+			"m3": {"id": "m3", "status": "valid", "e": "AnimatedImage", "m": "image/gif", "s": {"gif": "https://i.redd.it/m3.gif", "mp4": "https://preview.redd.it/m3.gif?format=mp4&amp;s=b", "x": 1280, "y": 720}},
+			"m4": {"id": "m4", "status": "valid", "e": "Image", "m": "image/png", "s": {"u": "https://preview.redd.it/m4.png?s=c", "x": 2560, "y": 1440}}
+		}
+	}}]}}`
+
+	var response models.Response
+	if err := json.Unmarshal([]byte(listing), &response); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+
+	got, err := resolvePostCandidates(context.Background(), response.Data.Post[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := []imageCandidate{
+		{URL: "https://preview.redd.it/m1.jpg?width=3840&s=a", Width: 3840, Height: 2160, GalleryIndex: 1, Caption: "Dawn & mist", OutboundURL: "https://example.com/prints/1"},
+		{URL: "https://preview.redd.it/m3.gif?format=mp4&s=b", Width: 1280, Height: 720, GalleryIndex: 3, Caption: "Timelapse", GIFURL: "https://i.redd.it/m3.gif", MP4URL: "https://preview.redd.it/m3.gif?format=mp4&s=b"},
+		{URL: "https://preview.redd.it/m4.png?s=c", Width: 2560, Height: 1440, GalleryIndex: 4, Caption: "Dusk"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d candidates, got %+v", len(want), got)
+	}
+	for i := range want {
+		got[i].Post = nil
+		if got[i] != want[i] {
+			t.Fatalf("expected %+v, got %+v", want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	defaultMaxRetries = 3
+
+	// requestRetries is the retry policy shared by every request snoo-dl
+	// makes. It is configured from the command flags by configureRequests.
+	requestRetries = retryPolicy{
+		MaxRetries: defaultMaxRetries,
+		BaseDelay:  time.Second,
+		MaxDelay:   time.Minute,
+	}
+
+	// requestLimiter throttles every request and honors Reddit's rate-limit
+	// headers per host.
+	requestLimiter = &rateLimiter{}
+
+	// requestIdleTimeout aborts a response whose body sends nothing for this
+	// long, however long the whole download takes.
+	requestIdleTimeout = 30 * time.Second
+
+	errResponseStalled = errors.New("response stalled")
+)
+
+// statusError is a response with an unexpected status. what describes the
+// request, e.g. "download".
+type statusError struct {
+	what   string
+	status string
+	code   int
+}
+
+func newStatusError(what string, resp *http.Response) *statusError {
+	return &statusError{what: what, status: resp.Status, code: resp.StatusCode}
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s failed with status %s", e.what, e.status)
+}
+
+// retryPolicy controls how failed requests are retried. The delay between
+// attempts doubles from BaseDelay up to MaxDelay unless the server says
+// how long to wait.
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// rateLimiter spaces requests at least interval apart and pauses requests to
+// a host until its advertised rate-limit window resets. The zero value does
+// not limit anything.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	paused   map[string]time.Time
+}
+
+// configureRequests applies the --max-retries and --rate-limit flags to every
+// subsequent request. A rate of 0 disables the global request limit.
+func configureRequests(maxRetries int, requestsPerSecond float64) {
+	requestRetries.MaxRetries = maxRetries
+	requestLimiter.setRate(requestsPerSecond)
+}
+
+// doRequest sends req with httpClient, retrying network errors, 429s and 5xx
+// responses according to requestRetries. req must not have a body. Reading
+// the returned body fails once it stalls for requestIdleTimeout.
+func doRequest(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := req.URL.Host
+
+	for attempt := 0; ; attempt++ {
+		if err := requestLimiter.wait(ctx, host); err != nil {
+			return nil, err
+		}
+
+		attemptCtx, cancel := context.WithCancelCause(ctx)
+		resp, err := httpClient.Do(req.WithContext(attemptCtx))
+		if resp != nil {
+			requestLimiter.observe(host, resp.Header)
+			resp.Body = newIdleTimeoutBody(attemptCtx, cancel, resp.Body, requestIdleTimeout)
+		} else {
+			cancel(nil)
+		}
+		if attempt >= requestRetries.MaxRetries || ctx.Err() != nil || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := retryDelay(resp, attempt, requestRetries)
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// idleTimeoutBody is a response body that cancels its request when no data
+// arrives for timeout. Closing it releases the request.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleTimeoutBody(ctx context.Context, cancel context.CancelCauseFunc, body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	return &idleTimeoutBody{
+		ReadCloser: body,
+		ctx:        ctx,
+		cancel:     cancel,
+		timer:      time.AfterFunc(timeout, func() { cancel(errResponseStalled) }),
+		timeout:    timeout,
+	}
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && errors.Is(context.Cause(b.ctx), errResponseStalled) {
+		return n, fmt.Errorf("%w: no data for %s", errResponseStalled, b.timeout)
+	}
+	b.timer.Reset(b.timeout)
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+
+	return isRetryableStatus(resp.StatusCode)
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// isTransientError reports whether a request that failed with err may
+// succeed if it is made again later: network errors, stalled or interrupted
+// transfers, and the statuses doRequest retries. A 416 also counts, since
+// the part file it refers to has been removed by then.
+func isTransientError(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return isRetryableStatus(status.code) || status.code == http.StatusRequestedRangeNotSatisfiable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, errResponseStalled) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryDelay prefers the server's Retry-After header, then Reddit's
+// X-Ratelimit-Reset on a 429, and otherwise backs off exponentially with
+// jitter.
+func retryDelay(resp *http.Response, attempt int, policy retryPolicy) time.Duration {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(delay, policy.MaxDelay)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if delay, ok := parseSeconds(resp.Header.Get("X-Ratelimit-Reset")); ok {
+				return min(delay, policy.MaxDelay)
+			}
+		}
+	}
+
+	delay := policy.BaseDelay << attempt
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay > 0 {
+		delay = delay/2 + rand.N(delay/2+1)
+	}
+
+	return delay
+}
+
+// parseRetryAfter accepts both forms of Retry-After: delay seconds or an
+// HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if delay, ok := parseSeconds(value); ok {
+		return delay, true
+	}
+
+	when, err := http.ParseTime(strings.TrimSpace(value))
+	if err != nil {
+		return 0, false
+	}
+
+	return max(time.Until(when), 0), true
+}
+
+// parseSeconds parses a possibly fractional number of seconds, as sent in
+// Reddit's X-Ratelimit-* headers.
+func parseSeconds(value string) (time.Duration, bool) {
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) setRate(requestsPerSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = 0
+	if requestsPerSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+}
+
+// wait blocks until a request to host may be sent.
+func (l *rateLimiter) wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if until, ok := l.paused[host]; ok {
+		if until.After(start) {
+			start = until
+		} else {
+			delete(l.paused, host)
+		}
+	}
+	if l.interval > 0 {
+		if l.next.After(start) {
+			start = l.next
+		}
+		l.next = start.Add(l.interval)
+	}
+	l.mu.Unlock()
+
+	return sleepContext(ctx, start.Sub(now))
+}
+
+// observe pauses requests to host until the rate-limit window resets once
+// the X-Ratelimit-Remaining budget is used up.
+func (l *rateLimiter) observe(host string, header http.Header) {
+	remaining, err := strconv.ParseFloat(strings.TrimSpace(header.Get("X-Ratelimit-Remaining")), 64)
+	if err != nil || remaining >= 1 {
+		return
+	}
+	reset, ok := parseSeconds(header.Get("X-Ratelimit-Reset"))
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.paused == nil {
+		l.paused = make(map[string]time.Time)
+	}
+	l.paused[host] = time.Now().Add(reset)
+}
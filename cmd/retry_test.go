@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoRequestRetriesRateLimitedResponses(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	originalClient := httpClient
+	originalRetries := requestRetries
+	httpClient = server.Client()
+	requestRetries = retryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	defer func() {
+		httpClient = originalClient
+		requestRetries = originalRetries
+	}()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || attempts != 3 {
+		t.Fatalf("expected success on attempt 3, got status %d after %d attempts", resp.StatusCode, attempts)
+	}
+}
+
+func TestDoRequestGivesUpAfterMaxRetries(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	originalClient := httpClient
+	originalRetries := requestRetries
+	httpClient = server.Client()
+	requestRetries = retryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	defer func() {
+		httpClient = originalClient
+		requestRetries = originalRetries
+	}()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || attempts != 3 {
+		t.Fatalf("expected final 503 after 3 attempts, got status %d after %d attempts", resp.StatusCode, attempts)
+	}
+}
+
+func TestRetryDelayHonorsHeaders(t *testing.T) {
+	policy := retryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "7")
+	if got := retryDelay(resp, 0, policy); got != 7*time.Second {
+		t.Fatalf("expected Retry-After delay of 7s, got %v", got)
+	}
+
+	resp.Header.Del("Retry-After")
+	resp.Header.Set("X-Ratelimit-Reset", "12")
+	if got := retryDelay(resp, 0, policy); got != 12*time.Second {
+		t.Fatalf("expected X-Ratelimit-Reset delay of 12s, got %v", got)
+	}
+
+	if got := retryDelay(nil, 2, policy); got < 2*time.Second || got > 4*time.Second {
+		t.Fatalf("expected jittered backoff between 2s and 4s, got %v", got)
+	}
+}
+
+func TestRateLimiterPausesHostWhenBudgetIsSpent(t *testing.T) {
+	limiter := &rateLimiter{}
+	header := http.Header{}
+	header.Set("X-Ratelimit-Remaining", "0.0")
+	header.Set("X-Ratelimit-Reset", "60")
+	limiter.observe("www.reddit.com", header)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, "www.reddit.com"); err == nil {
+		t.Fatal("expected the paused host to wait past the deadline")
+	}
+	if err := limiter.wait(context.Background(), "i.redd.it"); err != nil {
+		t.Fatalf("expected other hosts not to be paused, got %v", err)
+	}
+}
+
+// setRequestIdleTimeout shortens requestIdleTimeout for the rest of the test.
+func setRequestIdleTimeout(t *testing.T, timeout time.Duration) {
+	t.Helper()
+
+	original := requestIdleTimeout
+	requestIdleTimeout = timeout
+	t.Cleanup(func() { requestIdleTimeout = original })
+}
+
+func TestDoRequestAbortsStalledBody(t *testing.T) {
+	setRequestIdleTimeout(t, 50*time.Millisecond)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.ReadAll(resp.Body); !errors.Is(err, errResponseStalled) {
+		t.Fatalf("expected the stalled body to be aborted, got %v", err)
+	}
+}
+
+func TestDoRequestAllowsSlowBodyThatKeepsArriving(t *testing.T) {
+	setRequestIdleTimeout(t, 100*time.Millisecond)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 8; i++ {
+			_, _ = w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(25 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || len(body) != 40 {
+		t.Fatalf("expected the whole body to arrive, got %d bytes (%v)", len(body), err)
+	}
+}
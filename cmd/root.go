@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
@@ -21,8 +25,13 @@ var rootCmd = &cobra.Command{
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
+// The command context is cancelled on SIGINT or SIGTERM so downloads can stop
+// cleanly.
 func Execute() {
-	cobra.CheckErr(rootCmd.Execute())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	cobra.CheckErr(err)
 }
 
 func init() {
@@ -47,10 +56,17 @@ func initConfig() {
 		viper.SetConfigName(".snoodl")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in SNOODL_* environment variables that match, i.e. SNOODL_ASPECT_RATIO
+	// for aspect-ratio and SNOODL_AUTH_CLIENT_ID for auth.client_id
+	viper.SetEnvPrefix("snoodl")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
+
+	// Authenticate listing requests if OAuth2 credentials are configured.
+	cobra.CheckErr(configureAuth())
 }
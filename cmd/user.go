@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	redditUserURL = "https://www.reddit.com/user"
+
+	defaultUserSort = "new"
+
+	// validUserSorts maps each submissions sort to whether Reddit honors the
+	// time period (t=) parameter for it.
+	validUserSorts = map[string]bool{
+		"hot":           false,
+		"new":           false,
+		"top":           true,
+		"controversial": true,
+	}
+
+	validUsername = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// userCmd represents the user command
+var userCmd = &cobra.Command{
+	Use:   "user {USERNAME} [day|week|month|year|all]",
+	Short: "Download images submitted by a specified user",
+	Long: `user - will download all images submitted by the specific user, across
+	every subreddit they post to.
+	Default: SORT=new
+	TOP_PERIOD is only accepted for the top and controversial sorts.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		s, err := commandSettings(cmd)
+		if err != nil {
+			return err
+		}
+		if len(args) > 2 || len(args) == 0 {
+			return errors.New("invalid arguments")
+		}
+
+		if _, err := parseUsername(args[0]); err != nil {
+			return err
+		}
+
+		sort := s.getString("sort")
+		if _, ok := validUserSorts[strings.ToLower(sort)]; !ok {
+			return errors.New("provided SORT was invalid. Valid sorts are: hot|new|top|controversial")
+		}
+
+		if len(args) == 2 {
+			if !validUserSorts[strings.ToLower(sort)] {
+				return fmt.Errorf("TOP_PERIOD cannot be used with sort %q", strings.ToLower(sort))
+			}
+			if !isValidTopPeriod(args[1]) {
+				return errors.New("provided TOP_PERIOD was invalid. Valid periods are: day|week|month|year|all")
+			}
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		username, err := parseUsername(args[0])
+		if err != nil {
+			return err
+		}
+
+		s, err := commandSettings(cmd)
+		if err != nil {
+			return err
+		}
+		opts, err := downloadOptionsFromConfig(s)
+		if err != nil {
+			return err
+		}
+
+		sort := s.getString("sort")
+		opts.User = username
+		opts.Sort = strings.ToLower(sort)
+		opts.Period = defaultTopPeriod
+		if len(args) == 2 {
+			opts.Period = strings.ToLower(args[1])
+		}
+
+		return getTopWallpapers(cmd.Context(), opts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.Flags().StringP("sort", "s", defaultUserSort, "submissions sort to download from (hot|new|top|controversial)")
+	addDownloadFlags(userCmd)
+}
+
+// parseUsername accepts a bare username or one prefixed with u/ or /u/.
+func parseUsername(value string) (string, error) {
+	name := strings.TrimSpace(value)
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "u/")
+	if name == "" || !validUsername.MatchString(name) {
+		return "", fmt.Errorf("invalid username %q", value)
+	}
+
+	return name, nil
+}
+
+// userSubmittedURL builds the JSON listing URL for a user's submissions, only
+// adding the time period for sorts that honor it.
+func userSubmittedURL(username string, sort string, timesort string, after string, limit int) string {
+	query := url.Values{}
+	query.Set("sort", strings.ToLower(sort))
+	if validUserSorts[strings.ToLower(sort)] {
+		query.Set("t", timesort)
+	}
+	query.Set("limit", strconv.Itoa(limit))
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	return fmt.Sprintf("%s/%s/submitted.json?%s", redditUserURL, username, query.Encode())
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/viper"
+)
+
+func TestParseUsername(t *testing.T) {
+	got, err := parseUsername("/u/some_photographer")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got != "some_photographer" {
+		t.Fatalf("expected some_photographer, got %q", got)
+	}
+
+	if _, err := parseUsername("not a user"); err == nil {
+		t.Fatal("expected an error for an invalid username")
+	}
+}
+
+func TestUserSubmittedURL(t *testing.T) {
+	originalUserURL := redditUserURL
+	redditUserURL = "https://reddit.test/user"
+	defer func() { redditUserURL = originalUserURL }()
+
+	got := userSubmittedURL("someone", "top", "year", "t3_abc", 100)
+	want := "https://reddit.test/user/someone/submitted.json?after=t3_abc&limit=100&sort=top&t=year"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	got = userSubmittedURL("someone", "new", "year", "", 100)
+	want = "https://reddit.test/user/someone/submitted.json?limit=100&sort=new"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestGetTopWallpapersPaginatesUserSubmissions(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/user/someone/submitted.json", func(w http.ResponseWriter, r *http.Request) {
+		out := models.Response{}
+		switch r.URL.Query().Get("after") {
+		case "":
+			out.Data.Post = []models.Post{
+				{Data: models.PostData{Title: "first", URLOverriddenByDest: serverURL + "/img/first.jpg"}},
+			}
+			out.Data.After = "page2"
+		default:
+			out.Data.Post = []models.Post{
+				{Data: models.PostData{Title: "second", URLOverriddenByDest: serverURL + "/img/second.png"}},
+			}
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalUserURL := redditUserURL
+	originalClient := httpClient
+	redditUserURL = server.URL + "/user"
+	httpClient = server.Client()
+	defer func() {
+		redditUserURL = originalUserURL
+		httpClient = originalClient
+	}()
+
+	err := getTopWallpapers(context.Background(), downloadOptions{
+		User:        "someone",
+		Sort:        "new",
+		Location:    tmpDir,
+		Limit:       10,
+		Concurrency: 1,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, name := range []string{"first.jpg", "second.png"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+	}
+}
+
+func TestUserSortIgnoresDownloadSortSetting(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	t.Setenv("SNOODL_SORT", "rising")
+
+	viper.SetEnvPrefix("snoodl")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	viper.AutomaticEnv()
+
+	if err := userCmd.Args(userCmd, []string{"someone"}); err != nil {
+		t.Fatalf("expected the download sort to be ignored, got %v", err)
+	}
+	s, err := commandSettings(userCmd)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := s.getString("sort"); got != defaultUserSort {
+		t.Fatalf("expected the default user sort, got %q", got)
+	}
+
+	t.Setenv("SNOODL_USER_SORT", "top")
+	if got := s.getString("sort"); got != "top" {
+		t.Fatalf("expected SNOODL_USER_SORT to set the user sort, got %q", got)
+	}
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var defaultWatchInterval = 15 * time.Minute
+
+// watchState holds the fullname of the newest post seen in each watched
+// subreddit. Posts observed during a poll only become the new marks once
+// the poll is committed, so a failed poll is retried from the old marks. A
+// mark never moves past a post whose download failed, so it is retried too.
+type watchState struct {
+	path string
+
+	mu       sync.Mutex
+	newest   map[string]string
+	observed map[string][]string
+	failed   map[string]string
+}
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch {SUBREDDIT[+SUBREDDIT...]}",
+	Short: "Poll subreddits and download new posts as they appear",
+	Long: `watch - polls the new listing of the subreddits every --interval and
+	downloads the images of posts submitted since the previous poll. The newest
+	post seen in each subreddit is remembered between runs, so restarting
+	watch does not scan old posts again.
+	Stop it with Ctrl+C (SIGINT) or SIGTERM; a poll in progress is cancelled.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		s, err := commandSettings(cmd)
+		if err != nil {
+			return err
+		}
+
+		_, err = watchSubredditsFromSettings(s, args)
+		return err
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := commandSettings(cmd)
+		if err != nil {
+			return err
+		}
+		subreddits, err := watchSubredditsFromSettings(s, args)
+		if err != nil {
+			return err
+		}
+		interval := s.getDuration("interval")
+		if interval <= 0 {
+			return errors.New("interval must be greater than 0")
+		}
+
+		opts, err := downloadOptionsFromConfig(s)
+		if err != nil {
+			return err
+		}
+		opts.Subreddits = subreddits
+		opts.Sort = "new"
+		opts.Period = defaultTopPeriod
+		opts.Watch, err = openDefaultWatchState()
+		if err != nil {
+			return err
+		}
+
+		return watchListing(cmd.Context(), cmd.OutOrStdout(), opts, interval)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().StringArray("subreddit", nil, "subreddit to watch, may be repeated or use a+b syntax")
+	watchCmd.Flags().Duration("interval", defaultWatchInterval, "time to wait between polls (i.e. 5m, 1h)")
+	addDownloadFlags(watchCmd)
+}
+
+// watchSubredditsFromSettings resolves the subreddits to watch from the
+// positional SUBREDDIT argument and the subreddit setting.
+func watchSubredditsFromSettings(s settings, args []string) ([]string, error) {
+	if len(args) > 1 {
+		return nil, errors.New("invalid arguments")
+	}
+
+	values := s.getStringSlice("subreddit")
+	if len(args) == 1 {
+		values = append([]string{args[0]}, values...)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("a SUBREDDIT argument or --subreddit flag is required")
+	}
+
+	return parseSubreddits(values)
+}
+
+// watchListing downloads the new posts of opts' listing every interval until
+// ctx is cancelled. A failed poll is reported and retried at the next one.
+func watchListing(ctx context.Context, out io.Writer, opts downloadOptions, interval time.Duration) error {
+	for {
+		err := getTopWallpapers(ctx, opts)
+		if ctx.Err() != nil {
+			fmt.Fprintln(out, "watch stopped")
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(out, "poll failed:", err)
+		} else if err := opts.Watch.commit(); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "next poll at", time.Now().Add(interval).Format(time.Kitchen))
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			fmt.Fprintln(out, "watch stopped")
+			return nil
+		}
+	}
+}
+
+func watchStatePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "snoo-dl", "watch.json"), nil
+}
+
+func openDefaultWatchState() (*watchState, error) {
+	path, err := watchStatePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return openWatchState(path)
+}
+
+// openWatchState loads the marks stored at path. A missing file means no
+// subreddit has been polled yet.
+func openWatchState(path string) (*watchState, error) {
+	state := &watchState{
+		path:     path,
+		newest:   make(map[string]string),
+		observed: make(map[string][]string),
+		failed:   make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state.newest); err != nil {
+		return nil, fmt.Errorf("invalid watch state %s - %w", path, err)
+	}
+
+	return state, nil
+}
+
+// isNew reports whether the post fullname is newer than the committed mark
+// of subreddit. Every post is new to a nil state.
+func (w *watchState) isNew(subreddit string, fullname string) bool {
+	if w == nil {
+		return true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	mark, ok := w.newest[strings.ToLower(subreddit)]
+	return !ok || compareFullnames(fullname, mark) > 0
+}
+
+// observe records that the post fullname of subreddit was listed in the
+// current poll.
+func (w *watchState) observe(subreddit string, fullname string) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := strings.ToLower(subreddit)
+	w.observed[key] = append(w.observed[key], fullname)
+}
+
+// fail records that a download of the post fullname of subreddit failed,
+// keeping the mark of subreddit below it.
+func (w *watchState) fail(subreddit string, fullname string) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := strings.ToLower(subreddit)
+	if oldest, ok := w.failed[key]; !ok || compareFullnames(fullname, oldest) < 0 {
+		w.failed[key] = fullname
+	}
+}
+
+// commit advances the marks to the newest posts observed since the last
+// commit that are older than any failed post, and persists them.
+func (w *watchState) commit() error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, fullnames := range w.observed {
+		oldestFailed, hasFailed := w.failed[key]
+		for _, fullname := range fullnames {
+			if hasFailed && compareFullnames(fullname, oldestFailed) >= 0 {
+				continue
+			}
+			if mark, ok := w.newest[key]; !ok || compareFullnames(fullname, mark) > 0 {
+				w.newest[key] = fullname
+			}
+		}
+	}
+	w.observed = make(map[string][]string)
+	w.failed = make(map[string]string)
+
+	data, err := json.MarshalIndent(w.newest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(w.path, data, 0o644)
+}
+
+// compareFullnames orders two post fullnames (i.e. t3_1abc) by age. Reddit
+// assigns base36 IDs in increasing order, so a larger ID is a newer post.
+// Unparsable IDs fall back to comparing length and then text.
+func compareFullnames(a string, b string) int {
+	a = a[strings.IndexByte(a, '_')+1:]
+	b = b[strings.IndexByte(b, '_')+1:]
+
+	idA, errA := strconv.ParseUint(a, 36, 64)
+	idB, errB := strconv.ParseUint(b, 36, 64)
+	if errA == nil && errB == nil {
+		return cmp.Compare(idA, idB)
+	}
+	if byLength := cmp.Compare(len(a), len(b)); byLength != 0 {
+		return byLength
+	}
+
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+	"github.com/spf13/viper"
+)
+
+func TestCompareFullnames(t *testing.T) {
+	if compareFullnames("t3_zz", "t3_100") >= 0 {
+		t.Fatal("expected t3_zz to be older than t3_100")
+	}
+	if compareFullnames("t3_1abd", "t3_1abc") <= 0 {
+		t.Fatal("expected t3_1abd to be newer than t3_1abc")
+	}
+	if compareFullnames("t3_abc", "t3_abc") != 0 {
+		t.Fatal("expected equal fullnames to compare equal")
+	}
+}
+
+func TestGetTopWallpapersWatchOnlyQueuesNewPosts(t *testing.T) {
+	tmpDir := t.TempDir()
+	var serverURL string
+	var polls atomic.Int32
+	var lastPageRequests atomic.Int32
+
+	post := func(id string) models.Post {
+		return models.Post{Data: models.PostData{ID: id, Title: id, URLOverriddenByDest: serverURL + "/img/" + id + ".jpg"}}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/r/test/new.json", func(w http.ResponseWriter, r *http.Request) {
+		out := models.Response{}
+		switch r.URL.Query().Get("after") {
+		case "":
+			if polls.Add(1) == 1 {
+				out.Data.Post = []models.Post{post("c"), post("b")}
+			} else {
+				out.Data.Post = []models.Post{post("d"), post("c")}
+			}
+			out.Data.After = "t3_b"
+		case "t3_b":
+			out.Data.Post = []models.Post{post("a")}
+			out.Data.After = "t3_a"
+		default:
+			lastPageRequests.Add(1)
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	defer func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+	}()
+
+	statePath := filepath.Join(t.TempDir(), "watch.json")
+	state, err := openWatchState(statePath)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	opts := downloadOptions{
+		Subreddits:  []string{"test"},
+		Sort:        "new",
+		Period:      defaultTopPeriod,
+		Location:    tmpDir,
+		Limit:       3,
+		Concurrency: 2,
+		Watch:       state,
+	}
+
+	if err := getTopWallpapers(context.Background(), opts); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := state.commit(); err != nil {
+		t.Fatalf("expected no error committing marks, got %v", err)
+	}
+
+	reloaded, err := openWatchState(statePath)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if reloaded.isNew("test", "t3_c") || !reloaded.isNew("test", "t3_d") {
+		t.Fatalf("expected the stored mark to be t3_c, got %v", reloaded.newest)
+	}
+
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := os.Remove(filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("expected %s to have been downloaded: %v", name, err)
+		}
+	}
+
+	opts.Watch = reloaded
+	if err := getTopWallpapers(context.Background(), opts); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to read temp directory: %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "d.jpg" {
+		t.Fatalf("expected only d.jpg to be downloaded by the second poll, got %v", files)
+	}
+	if got := lastPageRequests.Load(); got != 0 {
+		t.Fatalf("expected paging to stop at a page without new posts, got %d extra requests", got)
+	}
+}
+
+func TestWatchListingStopsWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- watchListing(ctx, &out, downloadOptions{
+			Subreddits:  []string{"test"},
+			Sort:        "new",
+			Location:    t.TempDir(),
+			Limit:       1,
+			Concurrency: 1,
+		}, time.Hour)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected watch to stop after the context was cancelled")
+	}
+	if !strings.Contains(out.String(), "watch stopped") {
+		t.Fatalf("expected a shutdown message, got %q", out.String())
+	}
+}
+
+func TestWatchStateCommitStopsBelowFailedPost(t *testing.T) {
+	state, err := openWatchState(filepath.Join(t.TempDir(), "watch.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, fullname := range []string{"t3_d", "t3_c", "t3_b", "t3_a"} {
+		state.observe("test", fullname)
+	}
+	state.fail("test", "t3_c")
+	if err := state.commit(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if state.isNew("test", "t3_b") || !state.isNew("test", "t3_c") || !state.isNew("test", "t3_d") {
+		t.Fatalf("expected the mark to stay below the failed post, got %v", state.newest)
+	}
+
+	state.observe("test", "t3_d")
+	state.observe("test", "t3_c")
+	if err := state.commit(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if state.isNew("test", "t3_d") {
+		t.Fatalf("expected the mark to advance once the retry succeeds, got %v", state.newest)
+	}
+}
+
+func TestGetTopWallpapersWatchRetriesFailedDownloads(t *testing.T) {
+	state := watchWithFailingPost(t, "https://example.com/img/b.jpg", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	if state.isNew("test", "t3_a") || !state.isNew("test", "t3_b") {
+		t.Fatalf("expected the failed post to be polled again, got %v", state.newest)
+	}
+}
+
+func TestGetTopWallpapersWatchSkipsPermanentFailures(t *testing.T) {
+	state := watchWithFailingPost(t, "https://example.com/img/b.jpg", http.NotFound)
+
+	if state.isNew("test", "t3_b") || state.isNew("test", "t3_c") {
+		t.Fatalf("expected a missing image not to hold the mark, got %v", state.newest)
+	}
+}
+
+func TestGetTopWallpapersWatchRetriesFailedResolves(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("imgur.client_id", "test-client")
+	state := watchWithFailingPost(t, "https://imgur.com/a/album", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	if state.isNew("test", "t3_a") || !state.isNew("test", "t3_b") {
+		t.Fatalf("expected the post that failed to resolve to be polled again, got %v", state.newest)
+	}
+}
+
+// watchWithFailingPost downloads the posts c, b and a into a new watch state
+// and commits it. Post b links to link, whose download or Imgur API request
+// is answered by failing, without retries.
+func watchWithFailingPost(t *testing.T, link string, failing http.HandlerFunc) *watchState {
+	t.Helper()
+
+	var serverURL string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/ok.jpg", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-image"))
+	})
+	mux.HandleFunc("/img/b.jpg", failing)
+	mux.HandleFunc("/3/", failing)
+	mux.HandleFunc("/r/test/new.json", func(w http.ResponseWriter, r *http.Request) {
+		failingURL := strings.Replace(link, "https://example.com", serverURL, 1)
+		out := models.Response{}
+		out.Data.Post = []models.Post{
+			{Data: models.PostData{ID: "c", Title: "c", URLOverriddenByDest: serverURL + "/img/ok.jpg"}},
+			{Data: models.PostData{ID: "b", Title: "b", URLOverriddenByDest: failingURL}},
+			{Data: models.PostData{ID: "a", Title: "a", URLOverriddenByDest: serverURL + "/img/ok.jpg"}},
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	serverURL = server.URL
+
+	originalRedditURL := redditURL
+	originalClient := httpClient
+	originalAPIURL := imgurAPIURL
+	originalRetries := requestRetries
+	redditURL = server.URL + "/r"
+	httpClient = server.Client()
+	imgurAPIURL = server.URL + "/3"
+	requestRetries = retryPolicy{}
+	t.Cleanup(func() {
+		redditURL = originalRedditURL
+		httpClient = originalClient
+		imgurAPIURL = originalAPIURL
+		requestRetries = originalRetries
+	})
+
+	state, err := openWatchState(filepath.Join(t.TempDir(), "watch.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = getTopWallpapers(context.Background(), downloadOptions{
+		Subreddits:  []string{"test"},
+		Sort:        "new",
+		Period:      defaultTopPeriod,
+		Location:    t.TempDir(),
+		Limit:       3,
+		Concurrency: 2,
+		Watch:       state,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := state.commit(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return state
+}
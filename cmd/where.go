@@ -0,0 +1,659 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+// whereApproxTolerance is how far, relative to the right operand, two numbers
+// compared with ~ may be apart.
+const whereApproxTolerance = 0.02
+
+// whereFilter is a parsed --where expression such as
+// "width >= 2560 && ratio ~ 16:9 && score > 500 && !nsfw".
+type whereFilter struct {
+	source string
+	root   whereNode
+}
+
+func (f *whereFilter) Matches(candidate imageCandidate) bool {
+	return f.root.eval(candidate).boolean
+}
+
+// MatchesUnknownSize reports whether candidate, whose size is not known yet,
+// can still match once it is. Terms that refer to the size are treated as
+// unknown and the rest are evaluated as usual.
+func (f *whereFilter) MatchesUnknownSize(candidate imageCandidate) bool {
+	value, known := evalWithoutSize(f.root, candidate)
+	return value || !known
+}
+
+// whereSizeFields are the fields that depend on the size of the image.
+var whereSizeFields = map[string]bool{"width": true, "height": true, "ratio": true, "megapixels": true, "orientation": true}
+
+// evalWithoutSize evaluates a true/false node with three-valued logic: known
+// is false when the result depends on a size field.
+func evalWithoutSize(node whereNode, candidate imageCandidate) (value bool, known bool) {
+	switch n := node.(type) {
+	case whereNot:
+		value, known := evalWithoutSize(n.operand, candidate)
+		return !value, known
+	case whereLogical:
+		// false decides &&, and true decides ||, even if the other side is
+		// unknown.
+		left, leftKnown := evalWithoutSize(n.left, candidate)
+		if leftKnown && left != n.and {
+			return left, true
+		}
+		right, rightKnown := evalWithoutSize(n.right, candidate)
+		if rightKnown && right != n.and {
+			return right, true
+		}
+		return n.and, leftKnown && rightKnown
+	}
+	if usesSizeField(node) {
+		return false, false
+	}
+
+	return node.eval(candidate).boolean, true
+}
+
+// usesSizeField reports whether node refers to a size field.
+func usesSizeField(node whereNode) bool {
+	switch n := node.(type) {
+	case whereFieldRef:
+		return whereSizeFields[n.name]
+	case whereNot:
+		return usesSizeField(n.operand)
+	case whereLogical:
+		return usesSizeField(n.left) || usesSizeField(n.right)
+	case whereComparison:
+		return usesSizeField(n.left) || (n.right != nil && usesSizeField(n.right))
+	}
+
+	return false
+}
+
+type whereType int
+
+const (
+	whereBool whereType = iota
+	whereNumber
+	whereText
+)
+
+func (t whereType) String() string {
+	switch t {
+	case whereNumber:
+		return "a number"
+	case whereText:
+		return "text"
+	}
+
+	return "true/false"
+}
+
+// whereValue holds the result of evaluating a node; which field is set
+// depends on the node's type.
+type whereValue struct {
+	boolean bool
+	number  float64
+	text    string
+}
+
+// whereNode is a node of the expression AST. Types are checked while
+// parsing, so eval never fails.
+type whereNode interface {
+	valueType() whereType
+	eval(candidate imageCandidate) whereValue
+}
+
+type whereLiteral struct {
+	typ   whereType
+	value whereValue
+}
+
+func (n whereLiteral) valueType() whereType           { return n.typ }
+func (n whereLiteral) eval(imageCandidate) whereValue { return n.value }
+
+type whereField struct {
+	typ whereType
+	get func(candidate imageCandidate) whereValue
+}
+
+type whereFieldRef struct {
+	name  string
+	field whereField
+}
+
+func (n whereFieldRef) valueType() whereType { return n.field.typ }
+func (n whereFieldRef) eval(candidate imageCandidate) whereValue {
+	return n.field.get(candidate)
+}
+
+type whereNot struct {
+	operand whereNode
+}
+
+func (n whereNot) valueType() whereType { return whereBool }
+func (n whereNot) eval(candidate imageCandidate) whereValue {
+	return whereValue{boolean: !n.operand.eval(candidate).boolean}
+}
+
+type whereLogical struct {
+	and         bool
+	left, right whereNode
+}
+
+func (n whereLogical) valueType() whereType { return whereBool }
+func (n whereLogical) eval(candidate imageCandidate) whereValue {
+	left := n.left.eval(candidate).boolean
+	if n.and != left {
+		return whereValue{boolean: left}
+	}
+
+	return n.right.eval(candidate)
+}
+
+type whereComparison struct {
+	op          string
+	left, right whereNode
+
+	// pattern is the compiled right operand of a text ~ comparison.
+	pattern *regexp.Regexp
+}
+
+func (n whereComparison) valueType() whereType { return whereBool }
+func (n whereComparison) eval(candidate imageCandidate) whereValue {
+	left := n.left.eval(candidate)
+	if n.pattern != nil {
+		return whereValue{boolean: n.pattern.MatchString(left.text)}
+	}
+	right := n.right.eval(candidate)
+
+	var result bool
+	switch n.left.valueType() {
+	case whereBool:
+		result = (left.boolean == right.boolean) == (n.op == "==")
+	case whereText:
+		result = strings.EqualFold(left.text, right.text) == (n.op == "==")
+	default:
+		a, b := left.number, right.number
+		switch n.op {
+		case "==":
+			result = a == b
+		case "!=":
+			result = a != b
+		case "<":
+			result = a < b
+		case "<=":
+			result = a <= b
+		case ">":
+			result = a > b
+		case ">=":
+			result = a >= b
+		case "~":
+			result = math.Abs(a-b) <= whereApproxTolerance*math.Abs(b)
+		}
+	}
+
+	return whereValue{boolean: result}
+}
+
+func whereNumberField(get func(candidate imageCandidate, post models.PostData) float64) whereField {
+	return whereField{typ: whereNumber, get: func(candidate imageCandidate) whereValue {
+		return whereValue{number: get(candidate, candidatePost(candidate))}
+	}}
+}
+
+func whereTextField(get func(candidate imageCandidate, post models.PostData) string) whereField {
+	return whereField{typ: whereText, get: func(candidate imageCandidate) whereValue {
+		return whereValue{text: get(candidate, candidatePost(candidate))}
+	}}
+}
+
+func whereBoolField(get func(post models.PostData) bool) whereField {
+	return whereField{typ: whereBool, get: func(candidate imageCandidate) whereValue {
+		return whereValue{boolean: get(candidatePost(candidate))}
+	}}
+}
+
+// candidatePost returns the post of candidate, or an empty post if it is
+// unknown.
+func candidatePost(candidate imageCandidate) models.PostData {
+	if candidate.Post == nil {
+		return models.PostData{}
+	}
+
+	return *candidate.Post
+}
+
+// whereFields are the fields an expression can refer to.
+var whereFields = map[string]whereField{
+	"width":  whereNumberField(func(c imageCandidate, _ models.PostData) float64 { return float64(c.Width) }),
+	"height": whereNumberField(func(c imageCandidate, _ models.PostData) float64 { return float64(c.Height) }),
+	"ratio": whereNumberField(func(c imageCandidate, _ models.PostData) float64 {
+		if c.Height <= 0 {
+			return 0
+		}
+		return float64(c.Width) / float64(c.Height)
+	}),
+	"megapixels": whereNumberField(func(c imageCandidate, _ models.PostData) float64 {
+		return float64(c.Width) * float64(c.Height) / 1e6
+	}),
+	"score":        whereNumberField(func(_ imageCandidate, p models.PostData) float64 { return float64(p.Score) }),
+	"comments":     whereNumberField(func(_ imageCandidate, p models.PostData) float64 { return float64(p.NumComments) }),
+	"upvote_ratio": whereNumberField(func(_ imageCandidate, p models.PostData) float64 { return p.UpvoteRatio }),
+	"age": whereNumberField(func(_ imageCandidate, p models.PostData) float64 {
+		if p.CreatedUTC == 0 {
+			return 0
+		}
+		return time.Since(p.Created()).Hours() / 24
+	}),
+	"nsfw":      whereBoolField(func(p models.PostData) bool { return p.Over18 }),
+	"spoiler":   whereBoolField(func(p models.PostData) bool { return p.Spoiler }),
+	"gallery":   whereBoolField(func(p models.PostData) bool { return p.IsGallery }),
+	"title":     whereTextField(func(_ imageCandidate, p models.PostData) string { return p.Title }),
+	"author":    whereTextField(func(_ imageCandidate, p models.PostData) string { return p.Author }),
+	"subreddit": whereTextField(func(_ imageCandidate, p models.PostData) string { return p.Subreddit }),
+	"flair":     whereTextField(func(_ imageCandidate, p models.PostData) string { return p.LinkFlairText }),
+	"caption":   whereTextField(func(c imageCandidate, _ models.PostData) string { return c.Caption }),
+	"domain": whereTextField(func(c imageCandidate, _ models.PostData) string {
+		parsed, err := url.Parse(c.URL)
+		if err != nil {
+			return ""
+		}
+		return parsed.Hostname()
+	}),
+	"ext": whereTextField(func(c imageCandidate, _ models.PostData) string {
+		return strings.TrimPrefix(imageExtension(c.URL), ".")
+	}),
+	"orientation": whereTextField(func(c imageCandidate, _ models.PostData) string {
+		if c.Width <= 0 || c.Height <= 0 {
+			return ""
+		}
+		return models.Orientation(c.Width, c.Height)
+	}),
+}
+
+// whereSyntaxError is a problem at a column of the expression.
+type whereSyntaxError struct {
+	column  int
+	message string
+}
+
+func (e *whereSyntaxError) Error() string {
+	return fmt.Sprintf("%s at column %d", e.message, e.column)
+}
+
+// parseWhere parses a --where expression. Errors quote the expression and
+// point at the offending column.
+func parseWhere(source string) (*whereFilter, error) {
+	root, err := parseWhereSource(source)
+	if err != nil {
+		column := 1
+		var syntaxErr *whereSyntaxError
+		if errors.As(err, &syntaxErr) {
+			column = syntaxErr.column
+		}
+		return nil, fmt.Errorf("invalid --where expression: %w\n  %s\n  %s^", err, source, strings.Repeat(" ", column-1))
+	}
+
+	return &whereFilter{source: source, root: root}, nil
+}
+
+func parseWhereSource(source string) (whereNode, error) {
+	tokens, err := lexWhere(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &whereParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != whereTokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok.describe())
+	}
+	if root.valueType() != whereBool {
+		return nil, &whereSyntaxError{column: 1, message: fmt.Sprintf("expression must be true/false, not %s (i.e. width >= 1920)", root.valueType())}
+	}
+
+	return root, nil
+}
+
+type whereTokenKind int
+
+const (
+	whereTokEOF whereTokenKind = iota
+	whereTokNumber
+	whereTokString
+	whereTokIdent
+	whereTokOperator
+)
+
+type whereToken struct {
+	kind   whereTokenKind
+	text   string
+	number float64
+	column int
+}
+
+func (t whereToken) describe() string {
+	switch t.kind {
+	case whereTokEOF:
+		return "end of expression"
+	case whereTokString:
+		return strconv.Quote(t.text)
+	}
+
+	return "'" + t.text + "'"
+}
+
+// whereOperators lists the operators longest first so that "<=" is not
+// lexed as "<" followed by "=".
+var whereOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!", "<", ">", "~", "(", ")"}
+
+// lexWhere splits source into tokens. A ratio such as 16:9 is a single
+// number token holding 16/9.
+func lexWhere(source string) ([]whereToken, error) {
+	tokens := make([]whereToken, 0)
+	i := 0
+	for i < len(source) {
+		c := source[i]
+		column := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isWhereDigit(c) || (c == '.' && i+1 < len(source) && isWhereDigit(source[i+1])):
+			start := i
+			number, next, err := lexWhereNumber(source, i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			if i+1 < len(source) && source[i] == ':' && isWhereDigit(source[i+1]) {
+				denominator, next, err := lexWhereNumber(source, i+1)
+				if err != nil {
+					return nil, err
+				}
+				if denominator == 0 {
+					return nil, &whereSyntaxError{column: column, message: fmt.Sprintf("invalid ratio %q", source[start:next])}
+				}
+				number /= denominator
+				i = next
+			}
+			tokens = append(tokens, whereToken{kind: whereTokNumber, text: source[start:i], number: number, column: column})
+
+		case c == '_' || isWhereLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || isWhereLetter(source[i]) || isWhereDigit(source[i])) {
+				i++
+			}
+			tokens = append(tokens, whereToken{kind: whereTokIdent, text: strings.ToLower(source[start:i]), column: column})
+
+		case c == '\'' || c == '"':
+			var text strings.Builder
+			i++
+			for {
+				if i >= len(source) {
+					return nil, &whereSyntaxError{column: column, message: "unterminated string"}
+				}
+				if source[i] == c {
+					i++
+					break
+				}
+				if source[i] == '\\' && i+1 < len(source) && (source[i+1] == c || source[i+1] == '\\') {
+					i++
+				}
+				text.WriteByte(source[i])
+				i++
+			}
+			tokens = append(tokens, whereToken{kind: whereTokString, text: text.String(), column: column})
+
+		default:
+			operator := ""
+			for _, candidate := range whereOperators {
+				if strings.HasPrefix(source[i:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				message := fmt.Sprintf("unexpected character %q", source[i:i+1])
+				if c == '=' || c == '&' || c == '|' {
+					message += fmt.Sprintf(", did you mean %q?", string([]byte{c, c}))
+				}
+				return nil, &whereSyntaxError{column: column, message: message}
+			}
+			i += len(operator)
+			tokens = append(tokens, whereToken{kind: whereTokOperator, text: operator, column: column})
+		}
+	}
+
+	return append(tokens, whereToken{kind: whereTokEOF, column: len(source) + 1}), nil
+}
+
+func lexWhereNumber(source string, start int) (float64, int, error) {
+	end := start
+	for end < len(source) && (isWhereDigit(source[end]) || source[end] == '.') {
+		end++
+	}
+
+	number, err := strconv.ParseFloat(source[start:end], 64)
+	if err != nil {
+		return 0, end, &whereSyntaxError{column: start + 1, message: fmt.Sprintf("invalid number %q", source[start:end])}
+	}
+
+	return number, end, nil
+}
+
+func isWhereDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWhereLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// whereParser is a recursive descent parser over the grammar
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = primary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "~" | "=~" ) primary ]
+//	primary    = number | ratio | string | "true" | "false" | field | "(" or ")"
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() whereToken {
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) next() whereToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != whereTokEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *whereParser) isOperator(text string) bool {
+	tok := p.peek()
+	return tok.kind == whereTokOperator && tok.text == text
+}
+
+func (p *whereParser) errorf(tok whereToken, format string, args ...any) error {
+	return &whereSyntaxError{column: tok.column, message: fmt.Sprintf(format, args...)}
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	return p.parseLogical("&&", p.parseUnary)
+}
+
+func (p *whereParser) parseLogical(operator string, operand func() (whereNode, error)) (whereNode, error) {
+	start := p.peek()
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator(operator) {
+		opTok := p.next()
+		if left.valueType() != whereBool {
+			return nil, p.errorf(start, "left side of %s must be true/false, not %s", operator, left.valueType())
+		}
+		rightTok := p.peek()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if right.valueType() != whereBool {
+			return nil, p.errorf(rightTok, "right side of %s must be true/false, not %s", opTok.text, right.valueType())
+		}
+		left = whereLogical{and: operator == "&&", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *whereParser) parseUnary() (whereNode, error) {
+	if !p.isOperator("!") {
+		return p.parseComparison()
+	}
+
+	p.next()
+	tok := p.peek()
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if operand.valueType() != whereBool {
+		return nil, p.errorf(tok, "! needs a true/false value, not %s", operand.valueType())
+	}
+
+	return whereNot{operand: operand}, nil
+}
+
+func (p *whereParser) parseComparison() (whereNode, error) {
+	leftTok := p.peek()
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	opTok := p.peek()
+	if opTok.kind != whereTokOperator {
+		return left, nil
+	}
+	switch opTok.text {
+	case "==", "!=", "<", "<=", ">", ">=", "~", "=~":
+	default:
+		return left, nil
+	}
+	p.next()
+
+	rightTok := p.peek()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	op := opTok.text
+	if op == "=~" {
+		op = "~"
+	}
+	if left.valueType() != right.valueType() {
+		return nil, p.errorf(leftTok, "cannot compare %s with %s", left.valueType(), right.valueType())
+	}
+
+	comparison := whereComparison{op: op, left: left, right: right}
+	switch left.valueType() {
+	case whereBool:
+		if op != "==" && op != "!=" {
+			return nil, p.errorf(opTok, "%s cannot compare true/false values, use == or !=", opTok.text)
+		}
+	case whereText:
+		switch op {
+		case "==", "!=":
+		case "~":
+			literal, ok := right.(whereLiteral)
+			if !ok {
+				return nil, p.errorf(rightTok, "the right side of ~ must be a quoted regular expression")
+			}
+			comparison.pattern, err = regexp.Compile("(?i)" + literal.value.text)
+			if err != nil {
+				return nil, p.errorf(rightTok, "invalid regular expression - %v", err)
+			}
+		default:
+			return nil, p.errorf(opTok, "%s cannot compare text, use ==, != or ~", opTok.text)
+		}
+	default:
+		if opTok.text == "=~" {
+			return nil, p.errorf(opTok, "=~ matches text, use ~ to compare numbers approximately")
+		}
+	}
+
+	return comparison, nil
+}
+
+func (p *whereParser) parsePrimary() (whereNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case whereTokNumber:
+		return whereLiteral{typ: whereNumber, value: whereValue{number: tok.number}}, nil
+	case whereTokString:
+		return whereLiteral{typ: whereText, value: whereValue{text: tok.text}}, nil
+	case whereTokIdent:
+		switch tok.text {
+		case "true", "false":
+			return whereLiteral{typ: whereBool, value: whereValue{boolean: tok.text == "true"}}, nil
+		}
+		field, ok := whereFields[tok.text]
+		if !ok {
+			return nil, p.errorf(tok, "unknown field %q (fields are: %s)", tok.text, strings.Join(whereFieldNames(), ", "))
+		}
+		return whereFieldRef{name: tok.text, field: field}, nil
+	case whereTokOperator:
+		if tok.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.next(); closing.kind != whereTokOperator || closing.text != ")" {
+				return nil, p.errorf(closing, "expected ')' to close the '(' at column %d, got %s", tok.column, closing.describe())
+			}
+			return inner, nil
+		}
+	}
+
+	return nil, p.errorf(tok, "expected a value, got %s", tok.describe())
+}
+
+func whereFieldNames() []string {
+	names := make([]string, 0, len(whereFields))
+	for name := range whereFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shayd3/snoo-dl/models"
+)
+
+func TestWhereFilterMatches(t *testing.T) {
+	post := &models.PostData{
+		Title:         "Nebula over the Alps [OC]",
+		Author:        "alice",
+		Subreddit:     "spaceporn",
+		Score:         812,
+		Over18:        false,
+		LinkFlairText: "OC",
+	}
+	candidate := imageCandidate{URL: "https://i.redd.it/nebula.png", Width: 2560, Height: 1442, Post: post}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{"width >= 2560 && ratio ~ 16:9 && score > 500 && !nsfw", true},
+		{"width > 2560 || height < 1000", false},
+		{"!(width < 1920) && megapixels > 3", true},
+		{"ratio == 16:9", false},
+		{"flair == 'oc' && author != \"bob\"", true},
+		{"title ~ 'nebula|galaxy' && subreddit =~ '^space'", true},
+		{"title ~ '^galaxy'", false},
+		{"ext == 'png' && domain == 'i.redd.it' && orientation == 'landscape'", true},
+		{"nsfw == false && spoiler != true", true},
+		{"score > 100 && (nsfw || flair == 'OC')", true},
+	}
+
+	for _, tt := range tests {
+		where, err := parseWhere(tt.expression)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.expression, err)
+		}
+		if got := where.Matches(candidate); got != tt.want {
+			t.Fatalf("%s: expected %v, got %v", tt.expression, tt.want, got)
+		}
+	}
+}
+
+func TestParseWhereReportsErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{"widht >= 2560", `unknown field "widht"`},
+		{"width >= ", "expected a value, got end of expression at column 10"},
+		{"width = 2560", `unexpected character "=", did you mean "=="? at column 7`},
+		{"width >= 'big'", "cannot compare a number with text at column 1"},
+		{"title > 'a'", "> cannot compare text"},
+		{"title ~ author", "must be a quoted regular expression"},
+		{"title ~ '('", "invalid regular expression"},
+		{"width && nsfw", "left side of && must be true/false, not a number"},
+		{"score", "expression must be true/false, not a number"},
+		{"(nsfw", "expected ')' to close the '(' at column 1"},
+		{"nsfw nsfw", "unexpected 'nsfw' at column 6"},
+		{"title == 'open", "unterminated string at column 10"},
+		{"ratio ~ 16:0", `invalid ratio "16:0"`},
+	}
+
+	for _, tt := range tests {
+		_, err := parseWhere(tt.expression)
+		if err == nil {
+			t.Fatalf("%s: expected an error", tt.expression)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: expected error to contain %q, got %q", tt.expression, tt.want, err)
+		}
+	}
+}
+
+func TestParseWherePointsAtColumn(t *testing.T) {
+	_, err := parseWhere("width >= 1920 && hieght > 1080")
+	if err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 || strings.Index(lines[2], "^") != strings.Index(lines[1], "hieght") {
+		t.Fatalf("expected a caret under the unknown field, got:\n%s", err)
+	}
+}
+
+func TestFilterCandidatesCombinesFlagsAndWhere(t *testing.T) {
+	where, err := parseWhere("score >= 100")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	opts := downloadOptions{Filter: models.Filter{MinWidth: 1920}, Where: where}
+
+	candidates := []imageCandidate{
+		{URL: "https://i.redd.it/a.jpg", Width: 3840, Height: 2160, Post: &models.PostData{Score: 150}},
+		{URL: "https://i.redd.it/b.jpg", Width: 1280, Height: 720, Post: &models.PostData{Score: 150}},
+		{URL: "https://i.redd.it/c.jpg", Width: 3840, Height: 2160, Post: &models.PostData{Score: 50}},
+	}
+
+	filtered := filterCandidates(candidates, opts.candidateFilter())
+	if len(filtered) != 1 || filtered[0].URL != "https://i.redd.it/a.jpg" {
+		t.Fatalf("expected only a.jpg to match both filters, got %+v", filtered)
+	}
+}
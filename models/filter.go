@@ -1,8 +1,95 @@
 package models
 
+import (
+	"math"
+	"regexp"
+	"time"
+)
+
 type Filter struct {
 	ResolutionWidth   int
 	ResolutionHeight  int
 	AspectRatioWidth  int
 	AspectRatioHeight int
+
+	// MinWidth, MinHeight, MaxWidth and MaxHeight bound the image size.
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+
+	// AspectTolerance is how far, in percent, an image's aspect ratio may
+	// deviate from AspectRatioWidth:AspectRatioHeight.
+	AspectTolerance float64
+
+	// Orientation is landscape, portrait or square.
+	Orientation string
+}
+
+const (
+	OrientationLandscape = "landscape"
+	OrientationPortrait  = "portrait"
+	OrientationSquare    = "square"
+)
+
+// Matches reports whether the dimensions of image meet every criterion of f.
+// An image with unknown dimensions only matches an empty filter.
+func (f Filter) Matches(image Image) bool {
+	if (Filter{}) == f {
+		return true
+	}
+
+	if image.Width <= 0 || image.Height <= 0 {
+		return false
+	}
+
+	hasResolutionFilter := f.ResolutionWidth > 0 && f.ResolutionHeight > 0
+	hasAspectRatioFilter := f.AspectRatioWidth > 0 && f.AspectRatioHeight > 0
+
+	resolutionMatch := !hasResolutionFilter || (image.Height == f.ResolutionHeight && image.Width == f.ResolutionWidth)
+	aspectRatioMatch := !hasAspectRatioFilter || f.matchesAspectRatio(image.Width, image.Height)
+	sizeMatch := image.Width >= f.MinWidth && image.Height >= f.MinHeight &&
+		(f.MaxWidth <= 0 || image.Width <= f.MaxWidth) &&
+		(f.MaxHeight <= 0 || image.Height <= f.MaxHeight)
+	orientationMatch := f.Orientation == "" || Orientation(image.Width, image.Height) == f.Orientation
+
+	return resolutionMatch && aspectRatioMatch && sizeMatch && orientationMatch
+}
+
+// matchesAspectRatio compares the ratio of width and height with the filter's
+// aspect ratio, exactly or within AspectTolerance percent.
+func (f Filter) matchesAspectRatio(width int, height int) bool {
+	if f.AspectTolerance <= 0 {
+		return width*f.AspectRatioHeight == height*f.AspectRatioWidth
+	}
+
+	want := float64(f.AspectRatioWidth) / float64(f.AspectRatioHeight)
+	got := float64(width) / float64(height)
+	return math.Abs(got-want)/want*100 <= f.AspectTolerance
+}
+
+// Orientation returns landscape, portrait or square for an image size.
+func Orientation(width int, height int) string {
+	switch {
+	case width > height:
+		return OrientationLandscape
+	case height > width:
+		return OrientationPortrait
+	}
+
+	return OrientationSquare
+}
+
+// PostFilter holds the criteria a post must meet before its images are
+// considered. A zero value criterion is not applied.
+type PostFilter struct {
+	MinScore       int
+	NSFW           string
+	Flairs         []string
+	TitleMatch     *regexp.Regexp
+	TitleExclude   *regexp.Regexp
+	Authors        []string
+	ExcludeAuthors []string
+	Since          time.Time
+	Until          time.Time
 }
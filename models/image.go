@@ -0,0 +1,25 @@
+package models
+
+// Image is an image found in a post, with the dimensions Reddit reports for
+// it. Post is the post it was found in, shared by every image of that post.
+type Image struct {
+	URL    string
+	Width  int
+	Height int
+	Post   *PostData
+
+	// DashURL is set for Reddit videos to the DASH manifest of the video,
+	// whose URL is then the video-only fallback stream.
+	DashURL string
+
+	// MP4URL and GIFURL are set for animated images to the forms they are
+	// available in. URL is one of them, chosen by the --animated policy.
+	MP4URL string
+	GIFURL string
+
+	// GalleryIndex is the 1-based position of a gallery item in its gallery,
+	// or 0 for other images. Caption and OutboundURL are the item's own.
+	GalleryIndex int
+	Caption      string
+	OutboundURL  string
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // Post is a struct representing a reddit post
 type Post struct {
 	Kind string   `json:"kind"`
@@ -9,20 +11,79 @@ type Post struct {
 type PostData struct {
 	ID                  string               `json:"id"`
 	Title               string               `json:"title"`
+	Subreddit           string               `json:"subreddit"`
+	Author              string               `json:"author"`
+	Score               int                  `json:"score"`
+	CreatedUTC          float64              `json:"created_utc"`
+	Over18              bool                 `json:"over_18"`
+	Spoiler             bool                 `json:"spoiler"`
+	LinkFlairText       string               `json:"link_flair_text"`
+	Permalink           string               `json:"permalink"`
+	NumComments         int                  `json:"num_comments"`
+	UpvoteRatio         float64              `json:"upvote_ratio"`
 	Url                 string               `json:"url"`
 	URLOverriddenByDest string               `json:"url_overridden_by_dest"`
 	PostHint            string               `json:"post_hint"`
+	IsVideo             bool                 `json:"is_video"`
+	SecureMedia         *Media               `json:"secure_media"`
+	Media               *Media               `json:"media"`
 	IsGallery           bool                 `json:"is_gallery"`
 	Preview             Preview              `json:"preview"`
 	GalleryData         GalleryData          `json:"gallery_data"`
 	MediaMetadata       map[string]MediaMeta `json:"media_metadata"`
 }
 
+// Created returns the submission time of the post in UTC.
+func (p PostData) Created() time.Time {
+	return time.Unix(int64(p.CreatedUTC), 0).UTC()
+}
+
+// RedditVideo returns the v.redd.it video of the post, or nil if it has
+// none. secure_media is preferred over media, which Reddit fills the same.
+func (p PostData) RedditVideo() *RedditVideo {
+	for _, media := range []*Media{p.SecureMedia, p.Media} {
+		if media != nil && media.RedditVideo != nil {
+			return media.RedditVideo
+		}
+	}
+	return nil
+}
+
+type Media struct {
+	RedditVideo *RedditVideo `json:"reddit_video"`
+}
+
+// RedditVideo describes a video hosted on v.redd.it. FallbackURL is a
+// video-only MP4 of Height; the DASH manifest at DashURL lists every video
+// quality and the separate audio track.
+type RedditVideo struct {
+	FallbackURL string `json:"fallback_url"`
+	DashURL     string `json:"dash_url"`
+	HLSURL      string `json:"hls_url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Duration    int    `json:"duration"`
+	IsGIF       bool   `json:"is_gif"`
+}
+
 type Preview struct {
 	Images []PreviewImage `json:"images"`
 }
 
 type PreviewImage struct {
+	Source      ImageSource     `json:"source"`
+	Resolutions []ImageSource   `json:"resolutions"`
+	Variants    PreviewVariants `json:"variants"`
+}
+
+// PreviewVariants are other renditions of a preview image. Animated posts
+// have a GIF and an MP4 variant.
+type PreviewVariants struct {
+	GIF *PreviewVariant `json:"gif"`
+	MP4 *PreviewVariant `json:"mp4"`
+}
+
+type PreviewVariant struct {
 	Source      ImageSource   `json:"source"`
 	Resolutions []ImageSource `json:"resolutions"`
 }
@@ -37,15 +98,31 @@ type GalleryData struct {
 	Items []GalleryItem `json:"items"`
 }
 
+// GalleryItem is an entry of a gallery, in display order. Caption and
+// OutboundURL are what the poster attached to the item, if anything.
 type GalleryItem struct {
-	MediaID string `json:"media_id"`
+	MediaID     string `json:"media_id"`
+	ID          int64  `json:"id"`
+	Caption     string `json:"caption"`
+	OutboundURL string `json:"outbound_url"`
 }
 
+// MediaMeta describes the media of a gallery item. Status is "valid" once
+// Reddit has processed it, and E its kind: Image or AnimatedImage.
 type MediaMeta struct {
-	E string `json:"e"`
-	S struct {
-		U string `json:"u"`
-		X int    `json:"x"`
-		Y int    `json:"y"`
-	} `json:"s"`
+	ID     string      `json:"id"`
+	Status string      `json:"status"`
+	E      string      `json:"e"`
+	M      string      `json:"m"`
+	S      MediaSource `json:"s"`
+}
+
+// MediaSource is the full size rendition of a gallery item. Images have a
+// U URL, animated images GIF and MP4 URLs instead.
+type MediaSource struct {
+	U   string `json:"u"`
+	GIF string `json:"gif"`
+	MP4 string `json:"mp4"`
+	X   int    `json:"x"`
+	Y   int    `json:"y"`
 }